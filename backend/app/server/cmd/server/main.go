@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zenithalgo/api/internal/database"
 	"github.com/zenithalgo/api/internal/handlers"
 	myredis "github.com/zenithalgo/api/internal/infrastructure/redis"
 	"github.com/zenithalgo/api/internal/middleware"
+	"github.com/zenithalgo/api/internal/repository"
 	"github.com/zenithalgo/api/internal/services"
 )
 
@@ -21,28 +26,80 @@ func main() {
 
 	log.Println("✅ Connected to PostgreSQL database")
 
-	// Initialize Redis
-	rdb, err := myredis.NewClient(myredis.Config{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
+	// pgx pool backs the bulk-ingest write path (CopyFrom); sqlx/lib-pq
+	// above keeps serving the existing read path.
+	pgxPool, err := database.NewPgxPool(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to create pgx pool: %v", err)
+	}
+	defer pgxPool.Close()
+
+	// Initialize Redis. ZENITH_REDIS_MODE switches this between standalone,
+	// Sentinel and Cluster - see myredis.ConfigFromEnv.
+	redisCfg := myredis.ConfigFromEnv()
+	rdb, err := myredis.NewClient(redisCfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	log.Println("✅ Connected to Redis")
 
+	// Dedicated client for WSHub's zenith:jobs:events:* PSUBSCRIBE: Pub/Sub
+	// pins the connection for the life of the subscription, so it can't
+	// share rdb's pool with ordinary commands.
+	jobEventsRDB, err := myredis.NewClient(redisCfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis (job events): %v", err)
+	}
+
+	// Configure JWT auth: HS256 by default, or RS256 via a JWKS URL.
+	// Set ZENITH_AUTH_DEV_MODE=1 to bypass auth for local workflows.
+	// The HS256 default only applies when no JWKS URL is configured, since
+	// HS256Secret takes precedence over JWKSURL in middleware.Configure.
+	jwksURL := os.Getenv("ZENITH_AUTH_JWKS_URL")
+	hs256Secret := os.Getenv("ZENITH_AUTH_HS256_SECRET")
+	if hs256Secret == "" && jwksURL == "" {
+		hs256Secret = "dev-secret-change-me"
+	}
+	middleware.Configure(middleware.AuthConfig{
+		HS256Secret:  hs256Secret,
+		JWKSURL:      jwksURL,
+		TicketSecret: getEnv("ZENITH_AUTH_TICKET_SECRET", "dev-ticket-secret-change-me"),
+	})
+
+	// Only these Origin values may upgrade to /api/ws in production;
+	// ZENITH_AUTH_DEV_MODE=1 bypasses the check entirely.
+	handlers.ConfigureWSOrigins(getEnvList("ZENITH_WS_ALLOWED_ORIGINS", []string{"http://localhost:3000"}))
+
 	// Initialize services
-	backtestService := services.NewBacktestService(db)
+	backtestRepo := repository.NewBacktestRepository(pgxPool)
+	backtestService := services.NewBacktestService(db, backtestRepo)
 	jobService := services.NewJobService(rdb)
-	wsHub := services.NewWSHub(rdb)
+	jobReaper := services.NewJobReaper(rdb)
+	httpRateLimiter := services.NewRateLimiterFromEnv(rdb, "http")
+	wsRateLimiter := services.NewRateLimiterFromEnv(rdb, "ws")
+	wsHub := services.NewWSHub(rdb, jobEventsRDB, jobService.State(), wsRateLimiter)
+	webhookService := services.NewWebhookService(db, rdb)
+	resultPersister := services.NewResultPersister(rdb, backtestService)
 
 	// Start WS Hub
 	go wsHub.Run()
 
+	// Reclaim zenith:jobs:{queue}:stream entries a crashed worker left pending,
+	// re-dispatching or dead-lettering them per ZENITH_REAPER_MAX_ATTEMPTS.
+	jobReaper.Start(context.Background())
+
+	// Start webhook dispatch, subscribing to zenith:jobs:updates alongside the WS hub
+	webhookService.Start()
+
+	// Start the result persister, subscribing to zenith:jobs:updates to
+	// write completed job results to Postgres via the CopyFrom ingest path
+	resultPersister.Start()
+
 	// Initialize handlers
 	backtestHandler := handlers.NewBacktestHandler(backtestService)
 	jobHandler := handlers.NewJobHandler(jobService)
 	wsHandler := handlers.NewWSHandler(wsHub)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
 
 	// Setup router
 	router := gin.Default()
@@ -53,18 +110,43 @@ func main() {
 	// API routes
 	api := router.Group("/api")
 	{
-		api.GET("/sweep/results", backtestHandler.GetSweepResults)
-		api.GET("/backtest/:id/equity", backtestHandler.GetEquityCurve)
-		api.GET("/backtest/:id/trades", backtestHandler.GetTrades)
+		api.GET("/sweep/results", middleware.RequirePermission(middleware.PermRead), backtestHandler.GetSweepResults)
+		api.GET("/backtest/:id/equity", middleware.RequirePermission(middleware.PermRead), backtestHandler.GetEquityCurve)
+		api.GET("/backtest/:id/trades", middleware.RequirePermission(middleware.PermRead), backtestHandler.GetTrades)
 
 		// RaaS Routes
-		api.POST("/backtest", jobHandler.SubmitBacktest)
+		api.POST("/backtest", middleware.RequirePermission(middleware.PermWrite), middleware.RequireRateLimit(httpRateLimiter), jobHandler.SubmitBacktest)
+		api.GET("/ws/ticket", middleware.RequirePermission(middleware.PermRead), wsHandler.IssueTicket)
 		api.GET("/ws", wsHandler.HandleWS)
+
+		// Job queue visibility/recovery
+		jobs := api.Group("/jobs")
+		{
+			jobs.GET("", middleware.RequirePermission(middleware.PermRead), jobHandler.ListJobs)
+			jobs.GET("/pending", middleware.RequirePermission(middleware.PermRead), jobHandler.ListPending)
+			jobs.GET("/dlq", middleware.RequirePermission(middleware.PermRead), jobHandler.ListDLQ)
+			jobs.POST("/dlq/:id/requeue", middleware.RequirePermission(middleware.PermAdmin), jobHandler.RequeueDLQ)
+			jobs.GET("/:id", middleware.RequirePermission(middleware.PermRead), jobHandler.GetJob)
+			jobs.POST("/:id/cancel", middleware.RequirePermission(middleware.PermWrite), jobHandler.CancelJob)
+		}
+
+		// Webhook subscriptions
+		webhooks := api.Group("/webhooks")
+		{
+			webhooks.POST("", middleware.RequirePermission(middleware.PermAdmin), webhookHandler.CreateWebhook)
+			webhooks.GET("", middleware.RequirePermission(middleware.PermRead), webhookHandler.ListWebhooks)
+			webhooks.DELETE("/:id", middleware.RequirePermission(middleware.PermAdmin), webhookHandler.DeleteWebhook)
+			webhooks.POST("/:id/rotate-secret", middleware.RequirePermission(middleware.PermAdmin), webhookHandler.RotateSecret)
+			webhooks.POST("/:id/test-fire", middleware.RequirePermission(middleware.PermWrite), webhookHandler.TestFire)
+		}
 	}
 
 	// Health check
 	router.GET("/health", backtestHandler.HealthCheck)
 
+	// Operator metrics (WS hub backpressure, connected clients, etc.)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
 	// Start server
 	log.Println("🚀 Starting API server on http://localhost:8080")
 	log.Println("   Database: PostgreSQL")
@@ -72,3 +154,27 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList parses key as a comma-separated list, trimming whitespace
+// around each entry.
+func getEnvList(key string, defaultValue []string) []string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	parts := strings.Split(raw, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -41,6 +43,20 @@ func NewPostgresDB() (*sqlx.DB, error) {
 	return db, nil
 }
 
+// NewPgxPool creates a pgx connection pool used by the repository layer
+// for bulk ingest (CopyFrom), which sqlx/lib-pq doesn't support.
+func NewPgxPool(ctx context.Context) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(ctx, GetDatabaseURL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pgx pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database via pgx: %w", err)
+	}
+	return pool, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/zenithalgo/api/internal/middleware"
 	"github.com/zenithalgo/api/internal/models"
 	"github.com/zenithalgo/api/internal/services"
 
@@ -25,11 +26,108 @@ func (h *JobHandler) SubmitBacktest(c *gin.Context) {
 		return
 	}
 
-	jobID, err := h.service.SubmitBacktest(c, req)
+	resp, err := h.service.SubmitBacktest(c, req, middleware.UserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job"})
 		return
 	}
 
-	c.JSON(http.StatusAccepted, models.JobResponse{JobID: jobID})
+	c.JSON(http.StatusAccepted, resp)
+}
+
+// ListPending handles GET /api/jobs/pending. Non-admin callers only see
+// their own pending jobs, matching GetJob's ownsJob check.
+func (h *JobHandler) ListPending(c *gin.Context) {
+	pending, err := h.service.ListPending(c, middleware.UserID(c), middleware.IsAdmin(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pending": pending})
+}
+
+// ListDLQ handles GET /api/jobs/dlq. Non-admin callers only see their own
+// dead-lettered jobs, matching GetJob's ownsJob check.
+func (h *JobHandler) ListDLQ(c *gin.Context) {
+	dlq, err := h.service.ListDLQ(c, middleware.UserID(c), middleware.IsAdmin(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dlq": dlq})
+}
+
+// RequeueDLQ handles POST /api/jobs/dlq/:id/requeue
+func (h *JobHandler) RequeueDLQ(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.service.RequeueDLQ(c, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// GetJob handles GET /api/jobs/:id
+func (h *JobHandler) GetJob(c *gin.Context) {
+	state, err := h.service.GetJob(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if state == nil || !ownsJob(c, state.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// ListJobs handles GET /api/jobs?status=. Non-admin callers only see
+// their own jobs, matching GetJob's ownsJob check.
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	filter := models.JobStateFilter{Status: models.JobStatus(c.Query("status"))}
+	if !middleware.IsAdmin(c) {
+		filter.Owner = middleware.UserID(c)
+		filter.FilterByOwner = true
+	}
+
+	jobs, err := h.service.ListJobs(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// CancelJob handles POST /api/jobs/:id/cancel
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	id := c.Param("id")
+
+	state, err := h.service.GetJob(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if state == nil || !ownsJob(c, state.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	if err := h.service.CancelJob(c, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// ownsJob reports whether the caller may act on a job owned by owner:
+// admins may act on any job, everyone else only on jobs they themselves
+// submitted (matching WSHub's subscribe authorization).
+func ownsJob(c *gin.Context, owner string) bool {
+	return middleware.IsAdmin(c) || middleware.UserID(c) == owner
 }
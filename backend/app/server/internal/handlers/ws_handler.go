@@ -6,12 +6,38 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/zenithalgo/api/internal/middleware"
 	"github.com/zenithalgo/api/internal/services"
 )
 
+// allowedWSOrigins is the allowlist ConfigureWSOrigins installs at
+// startup. Outside middleware.DevModeEnabled, an Origin not in it is
+// rejected, and a nil/empty allowlist rejects every Origin, so upgrades
+// stay locked down by default.
+var allowedWSOrigins map[string]bool
+
+// ConfigureWSOrigins installs the set of Origin header values the WS
+// upgrader accepts; call once at startup. An empty origins list rejects
+// every upgrade that carries an Origin header, same-origin ones included;
+// only requests that omit Origin entirely are let through.
+func ConfigureWSOrigins(origins []string) {
+	allowedWSOrigins = make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowedWSOrigins[o] = true
+	}
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for local dev
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// No Origin header: not a browser cross-origin request.
+			return true
+		}
+		if middleware.DevModeEnabled() {
+			return true
+		}
+		return allowedWSOrigins[origin]
 	},
 }
 
@@ -23,25 +49,52 @@ func NewWSHandler(hub *services.WSHub) *WSHandler {
 	return &WSHandler{hub: hub}
 }
 
+// IssueTicket handles GET /api/ws/ticket, returning a short-lived signed
+// ticket so the WS upgrade below doesn't have to accept anonymous callers.
+func (h *WSHandler) IssueTicket(c *gin.Context) {
+	perms := []string{}
+	userID := ""
+	// RequirePermission never sets "claims" in dev mode, so this has to
+	// be a comma-ok c.Get like middleware.IsAdmin/UserID use elsewhere,
+	// not c.MustGet - otherwise every ticket request 500s whenever
+	// ZENITH_AUTH_DEV_MODE is on.
+	if v, ok := c.Get("claims"); ok {
+		if claims, ok := v.(*middleware.Claims); ok {
+			perms = claims.Perms
+			userID = claims.Subject
+		}
+	}
+
+	ticket, err := middleware.IssueWSTicket(userID, perms)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket})
+}
+
 func (h *WSHandler) HandleWS(c *gin.Context) {
+	var userID string
+	var isAdmin bool
+
+	if !middleware.DevModeEnabled() {
+		claims, err := middleware.ValidateWSTicket(c.Query("ticket"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid ws ticket"})
+			return
+		}
+		userID = claims.Subject
+		isAdmin = claims.HasPermission(middleware.PermAdmin)
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade WS: %v", err)
 		return
 	}
 
-	h.hub.RegisterClient(conn)
-
-	// Keep connection alive/reader loop
-	// For this simple Hub, we only push data TO client.
-	// But we need to read to detect disconnects.
-	go func() {
-		defer h.hub.UnregisterClient(conn)
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-		}
-	}()
+	// RegisterClient starts the hub-owned reader/writer pumps, which handle
+	// keepalive pings, deadlines and disconnect detection for this conn.
+	h.hub.RegisterClient(conn, userID, isAdmin)
 }
@@ -3,22 +3,109 @@ package redis
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Mode selects the Redis topology NewClient connects to.
+type Mode string
+
+const (
+	ModeSingle   Mode = "single"
+	ModeSentinel Mode = "sentinel"
+	ModeCluster  Mode = "cluster"
+)
+
 type Config struct {
-	Addr     string
+	// Mode defaults to ModeSingle (plain standalone Redis) when empty.
+	Mode Mode
+
+	// Addr is the single-node address, used when Mode is empty/"single".
+	Addr string
+	// Addrs is the sentinel address list (Mode "sentinel") or the cluster
+	// node address list (Mode "cluster").
+	Addrs []string
+
 	Password string
 	DB       int
+
+	// MasterName/SentinelPassword only apply to Mode "sentinel".
+	MasterName       string
+	SentinelPassword string
+
+	// RouteRandomly spreads read-only commands across cluster replicas
+	// instead of always hitting a slot's primary. Cluster-only.
+	RouteRandomly bool
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
-func NewClient(cfg Config) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+// NewClient builds a redis.UniversalClient for cfg.Mode, so callers (and
+// every downstream service) compile against a single interface regardless
+// of whether they end up talking to standalone Redis, a Sentinel-fronted
+// failover group, or a sharded Cluster.
+func NewClient(cfg Config) (redis.UniversalClient, error) {
+	var rdb redis.UniversalClient
+
+	switch cfg.Mode {
+	case ModeSentinel:
+		rdb = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+		})
+
+	case ModeCluster:
+		rdb = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:         cfg.Addrs,
+			Password:      cfg.Password,
+			RouteRandomly: cfg.RouteRandomly,
+			PoolSize:      cfg.PoolSize,
+			MinIdleConns:  cfg.MinIdleConns,
+			DialTimeout:   cfg.DialTimeout,
+			ReadTimeout:   cfg.ReadTimeout,
+			WriteTimeout:  cfg.WriteTimeout,
+		})
+
+	default:
+		rdb = redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		})
+	}
+
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		// A plain Ping only reaches whichever shard owns its slot; fan it
+		// out across every master so a down shard fails startup instead
+		// of surfacing on the first command routed to it.
+		if err := cc.ForEachMaster(context.Background(), func(ctx context.Context, shard *redis.Client) error {
+			return shard.Ping(ctx).Err()
+		}); err != nil {
+			return nil, fmt.Errorf("failed to connect to redis cluster: %w", err)
+		}
+		return rdb, nil
+	}
 
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
@@ -26,3 +113,66 @@ func NewClient(cfg Config) (*redis.Client, error) {
 
 	return rdb, nil
 }
+
+// ConfigFromEnv builds a Config from ZENITH_REDIS_* variables, so
+// switching a deployment from standalone Redis to Sentinel or Cluster is
+// an env change, not a code change:
+//
+//   - ZENITH_REDIS_MODE: "single" (default), "sentinel" or "cluster"
+//   - ZENITH_REDIS_ADDR: single-node address (Mode "single")
+//   - ZENITH_REDIS_ADDRS: comma-separated sentinel or cluster node
+//     addresses (Mode "sentinel"/"cluster")
+//   - ZENITH_REDIS_PASSWORD, ZENITH_REDIS_DB
+//   - ZENITH_REDIS_MASTER_NAME, ZENITH_REDIS_SENTINEL_PASSWORD (Mode
+//     "sentinel" only)
+//   - ZENITH_REDIS_ROUTE_RANDOMLY: "1" to spread reads across cluster
+//     replicas (Mode "cluster" only)
+func ConfigFromEnv() Config {
+	return Config{
+		Mode:             Mode(getEnv("ZENITH_REDIS_MODE", string(ModeSingle))),
+		Addr:             getEnv("ZENITH_REDIS_ADDR", "localhost:6379"),
+		Addrs:            getEnvList("ZENITH_REDIS_ADDRS"),
+		Password:         os.Getenv("ZENITH_REDIS_PASSWORD"),
+		DB:               envInt("ZENITH_REDIS_DB", 0),
+		MasterName:       os.Getenv("ZENITH_REDIS_MASTER_NAME"),
+		SentinelPassword: os.Getenv("ZENITH_REDIS_SENTINEL_PASSWORD"),
+		RouteRandomly:    os.Getenv("ZENITH_REDIS_ROUTE_RANDOMLY") == "1",
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// getEnvList parses key as a comma-separated list, trimming whitespace
+// around each entry. Returns nil (not an error) when key is unset, which
+// is fine for Mode "single" where Addrs goes unused.
+func getEnvList(key string) []string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	parts := strings.Split(raw, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func envInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Permission is a coarse-grained capability required to call a handler.
+// Handlers declare the permission they need when they're registered,
+// instead of the middleware inferring it from method/path strings.
+type Permission string
+
+const (
+	PermRead  Permission = "read"
+	PermWrite Permission = "write"
+	PermAdmin Permission = "admin"
+	PermSign  Permission = "sign"
+)
+
+// devModeEnv disables auth entirely so local workflows (and this repo's
+// own smoke tests) don't need a real JWT issuer running.
+const devModeEnv = "ZENITH_AUTH_DEV_MODE"
+
+// Claims is the JWT payload ZenithAlgo expects: a standard set of
+// registered claims plus the permission set granted to the bearer. The
+// bearer's user id rides in the standard `sub` claim (RegisteredClaims.Subject).
+type Claims struct {
+	jwt.RegisteredClaims
+	Perms []string `json:"perms"`
+}
+
+// HasPermission reports whether these claims grant perm.
+func (c *Claims) HasPermission(perm Permission) bool {
+	return hasPermission(c.Perms, perm)
+}
+
+// IsAdmin reports whether the request's claims grant PermAdmin. Like
+// UserID, it's "" / false when auth is disabled via dev mode, since
+// RequirePermission never sets claims in that case.
+func IsAdmin(c *gin.Context) bool {
+	if v, ok := c.Get("claims"); ok {
+		if claims, ok := v.(*Claims); ok {
+			return claims.HasPermission(PermAdmin)
+		}
+	}
+	return false
+}
+
+// AuthConfig configures how bearer tokens are validated. Exactly one of
+// HS256Secret or JWKSURL should be set; HS256Secret takes precedence.
+type AuthConfig struct {
+	HS256Secret string
+	JWKSURL     string
+	// TicketSecret signs the short-lived WS tickets issued by
+	// /api/ws/ticket, independent of how bearer tokens are validated.
+	TicketSecret string
+	keyFunc      jwt.Keyfunc
+}
+
+// wsTicketTTL is how long a ws connect ticket remains valid.
+const wsTicketTTL = 30 * time.Second
+
+var authConfig AuthConfig
+
+// Configure installs the AuthConfig used by RequirePermission and the WS
+// ticket helpers. Call once at startup.
+func Configure(cfg AuthConfig) {
+	if cfg.HS256Secret != "" {
+		cfg.keyFunc = func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrTokenSignatureInvalid
+			}
+			return []byte(cfg.HS256Secret), nil
+		}
+	} else if cfg.JWKSURL != "" {
+		cfg.keyFunc = jwksKeyFunc(cfg.JWKSURL)
+	}
+	authConfig = cfg
+}
+
+// RequirePermission validates the request's bearer JWT and rejects it
+// unless the token's `perms` claim grants perm. Set ZENITH_AUTH_DEV_MODE=1
+// to bypass auth entirely for local development.
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv(devModeEnv) == "1" {
+			c.Next()
+			return
+		}
+
+		tokenStr := extractBearerToken(c)
+		if tokenStr == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+
+		claims, err := parseClaims(tokenStr)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
+			return
+		}
+
+		if !hasPermission(claims.Perms, perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing required permission: " + string(perm)})
+			return
+		}
+
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// DevModeEnabled reports whether ZENITH_AUTH_DEV_MODE is set, letting
+// callers outside RequirePermission (e.g. the WS ticket check) skip auth
+// the same way local development already does.
+func DevModeEnabled() bool {
+	return os.Getenv(devModeEnv) == "1"
+}
+
+func extractBearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+func parseClaims(tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	if authConfig.keyFunc == nil {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, authConfig.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return claims, nil
+}
+
+func hasPermission(perms []string, required Permission) bool {
+	for _, p := range perms {
+		if p == string(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueWSTicket mints a short-lived signed ticket carrying userID and
+// perms, so a browser can obtain one over HTTP (where the Authorization
+// header is easy to attach) and then present it as a query param when
+// upgrading to a WebSocket, where custom headers aren't available.
+func IssueWSTicket(userID string, perms []string) (string, error) {
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(wsTicketTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		Perms: perms,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(authConfig.TicketSecret))
+}
+
+// ValidateWSTicket verifies a ticket minted by IssueWSTicket and returns
+// its claims, rejecting expired or tampered tickets.
+func ValidateWSTicket(ticket string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(ticket, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return []byte(authConfig.TicketSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return claims, nil
+}
+
+// jwksKeyFunc resolves the signing key for RS256 tokens from a JWKS
+// endpoint, matching on the token's `kid` header.
+func jwksKeyFunc(jwksURL string) jwt.Keyfunc {
+	set := newJWKSCache(jwksURL, 10*time.Minute)
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return set.key(kid)
+	}
+}
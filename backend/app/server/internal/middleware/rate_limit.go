@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zenithalgo/api/internal/services"
+)
+
+// UserID returns the authenticated caller's JWT subject, or "" if auth is
+// disabled (dev mode) or no claims were set on the context.
+func UserID(c *gin.Context) string {
+	if v, ok := c.Get("claims"); ok {
+		if claims, ok := v.(*Claims); ok {
+			return claims.Subject
+		}
+	}
+	return ""
+}
+
+// RateLimitKey derives the caller identity a RateLimiter should key on:
+// the authenticated user's subject when auth is enabled, falling back to
+// the remote address so dev-mode traffic is still limited per-caller.
+func RateLimitKey(c *gin.Context) string {
+	if userID := UserID(c); userID != "" {
+		return userID
+	}
+	return c.ClientIP()
+}
+
+// RequireRateLimit rejects the request with 429 once limiter's per-key
+// budget (see RateLimitKey) is exhausted. Mount it after RequirePermission
+// so claims are already set in the context.
+func RequireRateLimit(limiter *services.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := limiter.Allow(c, RateLimitKey(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,9 +1,37 @@
 package models
 
+import "time"
+
 type JobRequest struct {
 	Config map[string]interface{} `json:"config" binding:"required"`
 }
 
+// JobResponse is returned after a job is accepted onto the dispatch
+// stream. StreamID is the XADD-assigned entry ID, distinct from JobID
+// (which is a stable UUID the job keeps across reaper re-dispatch).
 type JobResponse struct {
-	JobID string `json:"job_id"`
+	JobID    string `json:"job_id"`
+	StreamID string `json:"stream_id"`
+}
+
+// PendingJobEntry describes one zenith:jobs:{queue}:stream entry that a worker
+// consumer has claimed but not yet XACKed, as reported by XPENDING.
+// JobID is read back from the stream entry itself so callers can apply
+// the same ownership check GetJob does.
+type PendingJobEntry struct {
+	StreamID   string        `json:"stream_id"`
+	JobID      string        `json:"job_id"`
+	Consumer   string        `json:"consumer"`
+	Idle       time.Duration `json:"idle"`
+	RetryCount int64         `json:"delivery_count"`
+}
+
+// DLQEntry is a job that exhausted JobReaper's MaxAttempts and was moved
+// to zenith:jobs:{queue}:dlq for operator inspection or manual requeue.
+type DLQEntry struct {
+	StreamID string                 `json:"stream_id"`
+	JobID    string                 `json:"job_id"`
+	Config   map[string]interface{} `json:"config"`
+	Attempt  int                    `json:"attempt"`
+	Reason   string                 `json:"reason"`
 }
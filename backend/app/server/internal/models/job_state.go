@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// JobStatus enumerates the lifecycle stages of a submitted backtest job.
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// JobProgress is the sub-document a worker updates via JSON.SET path as a
+// backtest runs, without rewriting the rest of JobState.
+type JobProgress struct {
+	Pct   float64 `json:"pct"`
+	Stage string  `json:"stage"`
+}
+
+// JobState is the full lifecycle document JobStateStore keeps per job,
+// keyed at zenith:jobs:{queue}:state:<job_id>. Owner is the submitting user's JWT
+// subject, used to authorize WS event subscriptions to this job.
+type JobState struct {
+	JobID           string                   `json:"job_id"`
+	Owner           string                   `json:"owner,omitempty"`
+	Status          JobStatus                `json:"status"`
+	Config          map[string]interface{}   `json:"config"`
+	SubmittedAt     time.Time                `json:"submitted_at"`
+	StartedAt       *time.Time               `json:"started_at,omitempty"`
+	FinishedAt      *time.Time               `json:"finished_at,omitempty"`
+	Progress        JobProgress              `json:"progress"`
+	Metrics         []map[string]interface{} `json:"metrics"`
+	Error           string                   `json:"error,omitempty"`
+	CancelRequested bool                     `json:"cancel_requested"`
+}
+
+// JobStateFilter narrows ListJobs; the zero value matches every job.
+// JobHandler sets FilterByOwner for every non-admin caller so ListJobs
+// can't be used to enumerate other users' jobs - FilterByOwner is a
+// separate bool rather than inferring "no filter" from Owner=="", since
+// an authenticated non-admin caller can legitimately have an empty user
+// ID (e.g. a token with no sub claim, or auth running in dev mode) and
+// that must still restrict results to Owner=="" jobs, not disable
+// filtering entirely.
+type JobStateFilter struct {
+	Status        JobStatus
+	Owner         string
+	FilterByOwner bool
+}
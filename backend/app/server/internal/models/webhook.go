@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// WebhookEvent identifies a stage in a backtest job's lifecycle.
+type WebhookEvent string
+
+const (
+	WebhookEventQueued   WebhookEvent = "queued"
+	WebhookEventStarted  WebhookEvent = "started"
+	WebhookEventProgress WebhookEvent = "progress"
+	WebhookEventSuccess  WebhookEvent = "success"
+	WebhookEventFailure  WebhookEvent = "failure"
+)
+
+// AllWebhookEvents lists every event a subscription can mask against.
+var AllWebhookEvents = []WebhookEvent{
+	WebhookEventQueued,
+	WebhookEventStarted,
+	WebhookEventProgress,
+	WebhookEventSuccess,
+	WebhookEventFailure,
+}
+
+// Webhook is a registered outbound subscription for job lifecycle events.
+type Webhook struct {
+	ID           int        `json:"id" db:"id"`
+	URL          string     `json:"url" db:"url"`
+	Secret       string     `json:"-" db:"secret"`
+	Events       EventMask  `json:"events" db:"events"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty" db:"last_delivery"`
+	FailureCount int        `json:"failure_count" db:"failure_count"`
+}
+
+// EventMask stores a set of WebhookEvent as a comma-separated TEXT column.
+type EventMask []WebhookEvent
+
+// Has reports whether the mask subscribes to the given event.
+func (m EventMask) Has(event WebhookEvent) bool {
+	for _, e := range m {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements driver.Valuer, storing the mask as a comma-separated list.
+func (m EventMask) Value() (driver.Value, error) {
+	parts := make([]string, len(m))
+	for i, e := range m {
+		parts[i] = string(e)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// Scan implements sql.Scanner for the comma-separated TEXT column.
+func (m *EventMask) Scan(value interface{}) error {
+	if value == nil {
+		*m = nil
+		return nil
+	}
+	raw, ok := value.(string)
+	if !ok {
+		if b, ok := value.([]byte); ok {
+			raw = string(b)
+		}
+	}
+	if raw == "" {
+		*m = nil
+		return nil
+	}
+	parts := strings.Split(raw, ",")
+	mask := make(EventMask, 0, len(parts))
+	for _, p := range parts {
+		mask = append(mask, WebhookEvent(p))
+	}
+	*m = mask
+	return nil
+}
+
+// WebhookDelivery records one attempt (successful or not) to deliver an
+// event to a subscriber, so failed attempts survive API restarts.
+type WebhookDelivery struct {
+	ID          int        `json:"id" db:"id"`
+	WebhookID   int        `json:"webhook_id" db:"webhook_id"`
+	EventID     string     `json:"event_id" db:"event_id"`
+	Event       string     `json:"event" db:"event"`
+	Payload     string     `json:"payload" db:"payload"`
+	Attempt     int        `json:"attempt" db:"attempt"`
+	StatusCode  int        `json:"status_code" db:"status_code"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	Delivered   bool       `json:"delivered" db:"delivered"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty" db:"next_retry_at"`
+}
+
+// CreateWebhookRequest is the CRUD payload for POST /api/webhooks.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events" binding:"required"`
+}
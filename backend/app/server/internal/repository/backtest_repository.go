@@ -0,0 +1,116 @@
+// Package repository holds the pgx-backed write path for backtest
+// results. It exists alongside the sqlx-backed read path in
+// services.BacktestService because sqlx/lib-pq has no CopyFrom support,
+// and ingesting tens of thousands of trades or a multi-year minute-bar
+// equity curve one row at a time is the dominant cost of saving a sweep.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenithalgo/api/internal/models"
+)
+
+type BacktestRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBacktestRepository(pool *pgxpool.Pool) *BacktestRepository {
+	return &BacktestRepository{pool: pool}
+}
+
+// SaveResult persists a backtest, its trades and its equity curve in one
+// transaction: a single row INSERT for the metadata, then a CopyFrom each
+// for trades and equity points so ingest is O(one batch) instead of
+// O(n round-trips).
+func (r *BacktestRepository) SaveResult(ctx context.Context, result *models.BacktestResult, trades []models.Trade, equity []models.EquityPoint) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO backtests (
+			run_id, symbol, timeframe, start_date, end_date,
+			strategy_name, params,
+			total_return, sharpe_ratio, max_drawdown, win_rate, total_trades,
+			score, passed, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5,
+			$6, $7,
+			$8, $9, $10, $11, $12,
+			$13, $14, $15
+		) RETURNING id
+	`,
+		result.RunID, result.Symbol, result.Timeframe, result.StartDate, result.EndDate,
+		result.StrategyName, result.Params,
+		result.Metrics.TotalReturn, result.Metrics.Sharpe, result.Metrics.MaxDrawdown, result.Metrics.WinRate, result.Metrics.TotalTrades,
+		result.Score, result.Passed, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to insert backtest: %w", err)
+	}
+
+	if err := r.copyTrades(ctx, tx, id, trades); err != nil {
+		return err
+	}
+	if err := r.copyEquityCurve(ctx, tx, id, equity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *BacktestRepository) copyTrades(ctx context.Context, tx pgx.Tx, backtestID int, trades []models.Trade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, len(trades))
+	for i, t := range trades {
+		rows[i] = []interface{}{
+			backtestID, t.Timestamp, t.Symbol, t.Side, t.Price, t.Qty,
+			t.PnL, t.Commission, t.CumulativePnL,
+		}
+	}
+
+	_, err := tx.CopyFrom(ctx,
+		pgx.Identifier{"trades"},
+		[]string{"backtest_id", "timestamp", "symbol", "side", "price", "qty", "pnl", "commission", "cumulative_pnl"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy trades: %w", err)
+	}
+	return nil
+}
+
+func (r *BacktestRepository) copyEquityCurve(ctx context.Context, tx pgx.Tx, backtestID int, equity []models.EquityPoint) error {
+	if len(equity) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, len(equity))
+	for i, e := range equity {
+		rows[i] = []interface{}{backtestID, e.Timestamp, e.Equity, e.Drawdown, e.DrawdownPct}
+	}
+
+	_, err := tx.CopyFrom(ctx,
+		pgx.Identifier{"equity_curves"},
+		[]string{"backtest_id", "timestamp", "equity", "drawdown", "drawdown_pct"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy equity curve: %w", err)
+	}
+	return nil
+}
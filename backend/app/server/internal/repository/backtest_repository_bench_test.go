@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenithalgo/api/internal/models"
+)
+
+// These benchmarks compare the old per-row INSERT loop against the
+// CopyFrom-based batch ingest on a 100k-trade payload. They need a real
+// Postgres instance (the schema's constraints matter for the comparison
+// to be honest), so they're skipped unless BENCH_DATABASE_URL is set.
+//
+//	BENCH_DATABASE_URL=postgres://... go test ./internal/repository/... -bench=Ingest100kTrades -run=^$
+func newBenchPool(b *testing.B) *pgxpool.Pool {
+	b.Helper()
+	dsn := os.Getenv("BENCH_DATABASE_URL")
+	if dsn == "" {
+		b.Skip("BENCH_DATABASE_URL not set, skipping ingest benchmark")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		b.Fatalf("failed to connect: %v", err)
+	}
+	b.Cleanup(pool.Close)
+	return pool
+}
+
+func benchTrades(n int) []models.Trade {
+	trades := make([]models.Trade, n)
+	pnl := 1.5
+	commission := 0.01
+	cumulative := 0.0
+	now := time.Now()
+	for i := range trades {
+		trades[i] = models.Trade{
+			Timestamp:     now.Add(time.Duration(i) * time.Minute),
+			Symbol:        "BTCUSDT",
+			Side:          "buy",
+			Price:         100.0,
+			Qty:           1.0,
+			PnL:           &pnl,
+			Commission:    &commission,
+			CumulativePnL: &cumulative,
+		}
+	}
+	return trades
+}
+
+// insertTradesPerRow is the pre-refactor write path, kept here only as a
+// benchmark baseline: one INSERT round-trip per trade row.
+func insertTradesPerRow(ctx context.Context, pool *pgxpool.Pool, backtestID int, trades []models.Trade) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, t := range trades {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO trades (backtest_id, timestamp, symbol, side, price, qty, pnl, commission, cumulative_pnl)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, backtestID, t.Timestamp, t.Symbol, t.Side, t.Price, t.Qty, t.PnL, t.Commission, t.CumulativePnL)
+		if err != nil {
+			return fmt.Errorf("failed to insert trade: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func BenchmarkIngest100kTradesPerRow(b *testing.B) {
+	pool := newBenchPool(b)
+	trades := benchTrades(100_000)
+	repo := NewBacktestRepository(pool)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := mustInsertBenchBacktest(b, repo)
+		if err := insertTradesPerRow(context.Background(), pool, id, trades); err != nil {
+			b.Fatalf("per-row insert failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkIngest100kTradesCopyFrom(b *testing.B) {
+	pool := newBenchPool(b)
+	trades := benchTrades(100_000)
+	repo := NewBacktestRepository(pool)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := mustInsertBenchBacktest(b, repo)
+		tx, err := pool.Begin(context.Background())
+		if err != nil {
+			b.Fatalf("failed to begin transaction: %v", err)
+		}
+		if err := repo.copyTrades(context.Background(), tx, id, trades); err != nil {
+			b.Fatalf("copy failed: %v", err)
+		}
+		if err := tx.Commit(context.Background()); err != nil {
+			b.Fatalf("failed to commit: %v", err)
+		}
+	}
+}
+
+func mustInsertBenchBacktest(b *testing.B, repo *BacktestRepository) int {
+	b.Helper()
+	var id int
+	err := repo.pool.QueryRow(context.Background(), `
+		INSERT INTO backtests (run_id, symbol, timeframe, start_date, end_date, strategy_name, params, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, fmt.Sprintf("bench-%d", time.Now().UnixNano()), "BTCUSDT", "1m", time.Now(), time.Now(), "bench", models.SweepParams{}, time.Now()).Scan(&id)
+	if err != nil {
+		b.Fatalf("failed to insert bench backtest: %v", err)
+	}
+	return id
+}
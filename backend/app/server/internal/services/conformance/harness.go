@@ -0,0 +1,87 @@
+// Package conformance pins the contract between a `zenith:jobs:updates`
+// success payload and the models.BacktestResult/Trade/EquityPoint rows
+// services.ParsePayload derives from it. Each vector under testdata/vectors
+// is a canonical payload plus the exact rows it must produce, so a change
+// to ParsePayload that silently alters parsing behavior fails a test
+// instead of shipping.
+//
+// This only pins ParsePayload's output, not what happens to it afterward:
+// BacktestRepository.SaveResult's CopyFrom ingest (the write path
+// ParsePayload's result actually flows into) has no coverage here and
+// would need a real or mocked pgxpool to add, which this package doesn't
+// set up. Treat a green TestVectors run as "the parser didn't regress",
+// not as "ingest is correct end to end".
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zenithalgo/api/internal/models"
+)
+
+// Vector is one canonical payload and the rows it must parse into.
+type Vector struct {
+	Name             string
+	Input            map[string]interface{}
+	ExpectedBacktest *models.BacktestResult
+	ExpectedTrades   []models.Trade
+	ExpectedEquity   []models.EquityPoint
+}
+
+// LoadVectors reads every subdirectory of dir as a Vector. Each
+// subdirectory must contain input.json, expected_backtest.json,
+// expected_trades.json and expected_equity.json.
+func LoadVectors(dir string) ([]Vector, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("conformance: read vectors dir: %w", err)
+	}
+
+	var vectors []Vector
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := loadVector(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("conformance: load vector %q: %w", entry.Name(), err)
+		}
+		v.Name = entry.Name()
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+func loadVector(dir string) (Vector, error) {
+	var v Vector
+
+	if err := readJSON(filepath.Join(dir, "input.json"), &v.Input); err != nil {
+		return v, err
+	}
+
+	v.ExpectedBacktest = &models.BacktestResult{}
+	if err := readJSON(filepath.Join(dir, "expected_backtest.json"), v.ExpectedBacktest); err != nil {
+		return v, err
+	}
+
+	if err := readJSON(filepath.Join(dir, "expected_trades.json"), &v.ExpectedTrades); err != nil {
+		return v, err
+	}
+
+	if err := readJSON(filepath.Join(dir, "expected_equity.json"), &v.ExpectedEquity); err != nil {
+		return v, err
+	}
+
+	return v, nil
+}
+
+func readJSON(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,46 @@
+package conformance
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/zenithalgo/api/internal/services"
+)
+
+// TestVectors replays every fixture under testdata/vectors through
+// services.ParsePayload and diffs the result against the pinned
+// expectation. It does not exercise BacktestRepository.SaveResult, so it
+// catches parser regressions, not ingest regressions - see the package
+// doc comment. Set SKIP_CONFORMANCE=1 to skip this in environments that
+// don't want the extra vector-loading I/O (e.g. quick unit test runs).
+func TestVectors(t *testing.T) {
+	if os.Getenv("SKIP_CONFORMANCE") == "1" {
+		t.Skip("SKIP_CONFORMANCE=1 set, skipping conformance vectors")
+	}
+
+	vectors, err := LoadVectors("testdata/vectors")
+	if err != nil {
+		t.Fatalf("failed to load vectors: %v", err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no conformance vectors found")
+	}
+
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			gotResult, gotTrades, gotEquity := services.ParsePayload(v.Input)
+
+			if !reflect.DeepEqual(gotResult, v.ExpectedBacktest) {
+				t.Errorf("backtest mismatch:\n got:  %+v\n want: %+v", gotResult, v.ExpectedBacktest)
+			}
+			if !reflect.DeepEqual(gotTrades, v.ExpectedTrades) {
+				t.Errorf("trades mismatch:\n got:  %+v\n want: %+v", gotTrades, v.ExpectedTrades)
+			}
+			if !reflect.DeepEqual(gotEquity, v.ExpectedEquity) {
+				t.Errorf("equity mismatch:\n got:  %+v\n want: %+v", gotEquity, v.ExpectedEquity)
+			}
+		})
+	}
+}
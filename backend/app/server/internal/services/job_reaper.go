@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultReaperInterval          = 30 * time.Second
+	defaultReaperVisibilityTimeout = 5 * time.Minute
+	defaultReaperMaxAttempts       = 3
+	reaperConsumerName             = "reaper"
+	reaperAutoClaimBatch           = 50
+)
+
+// JobReaper periodically reclaims zenith:jobs:{queue}:stream entries whose worker
+// consumer has gone idle past VisibilityTimeout, most likely because the
+// worker crashed mid-run. A reclaimed entry is either re-dispatched with
+// attempt incremented, or — once MaxAttempts is exhausted — moved to
+// zenith:jobs:{queue}:dlq and acknowledged, so it stops showing up as pending.
+type JobReaper struct {
+	redis             redis.UniversalClient
+	interval          time.Duration
+	visibilityTimeout time.Duration
+	maxAttempts       int
+}
+
+func NewJobReaper(rdb redis.UniversalClient) *JobReaper {
+	return &JobReaper{
+		redis:             rdb,
+		interval:          envDuration("ZENITH_REAPER_INTERVAL", defaultReaperInterval),
+		visibilityTimeout: envDuration("ZENITH_REAPER_VISIBILITY_TIMEOUT", defaultReaperVisibilityTimeout),
+		maxAttempts:       envInt("ZENITH_REAPER_MAX_ATTEMPTS", defaultReaperMaxAttempts),
+	}
+}
+
+// Start runs the reclaim loop on its own goroutine until ctx is canceled.
+func (r *JobReaper) Start(ctx context.Context) {
+	go r.loop(ctx)
+}
+
+func (r *JobReaper) loop(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reclaimOnce(ctx); err != nil {
+				log.Printf("JobReaper: reclaim pass failed: %v", err)
+			}
+		}
+	}
+}
+
+// reclaimOnce runs a single XAUTOCLAIM sweep over entries idle longer
+// than visibilityTimeout, re-dispatching or dead-lettering each in turn.
+func (r *JobReaper) reclaimOnce(ctx context.Context) error {
+	cursor := "0"
+	for {
+		messages, next, err := r.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   JobStreamKey,
+			Group:    JobConsumerGroup,
+			MinIdle:  r.visibilityTimeout,
+			Start:    cursor,
+			Count:    reaperAutoClaimBatch,
+			Consumer: reaperConsumerName,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("xautoclaim: %w", err)
+		}
+
+		for _, msg := range messages {
+			if err := r.handleClaimed(ctx, msg); err != nil {
+				log.Printf("JobReaper: failed to handle reclaimed entry %s: %v", msg.ID, err)
+			}
+		}
+
+		if next == "0" || len(messages) == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *JobReaper) handleClaimed(ctx context.Context, msg redis.XMessage) error {
+	jobID, _ := msg.Values["job_id"].(string)
+	config, _ := msg.Values["config"].(string)
+	attempt := 0
+	if v, ok := msg.Values["attempt"].(string); ok {
+		attempt, _ = strconv.Atoi(v)
+	}
+
+	if attempt+1 > r.maxAttempts {
+		if err := r.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: JobDLQKey,
+			Values: map[string]interface{}{
+				"job_id":  jobID,
+				"config":  config,
+				"attempt": attempt,
+				"reason":  fmt.Sprintf("exceeded max attempts (%d) after visibility timeout reclaim", r.maxAttempts),
+			},
+		}).Err(); err != nil {
+			return fmt.Errorf("failed to dead-letter job %s: %w", jobID, err)
+		}
+		log.Printf("JobReaper: job %s moved to dlq after %d attempts", jobID, attempt)
+		return r.redis.XAck(ctx, JobStreamKey, JobConsumerGroup, msg.ID).Err()
+	}
+
+	if err := r.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: JobStreamKey,
+		Values: map[string]interface{}{
+			"job_id":       jobID,
+			"config":       config,
+			"submitted_at": time.Now().UTC().Format(time.RFC3339),
+			"attempt":      attempt + 1,
+		},
+	}).Err(); err != nil {
+		return fmt.Errorf("failed to re-inject job %s: %w", jobID, err)
+	}
+	log.Printf("JobReaper: job %s reclaimed and re-dispatched (attempt %d)", jobID, attempt+1)
+	return r.redis.XAck(ctx, JobStreamKey, JobConsumerGroup, msg.ID).Err()
+}
+
+// envDuration reads key as a Go duration string (e.g. "30s"), falling
+// back to defaultValue if unset or unparsable.
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return defaultValue
+	}
+	return d
+}
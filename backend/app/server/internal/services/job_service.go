@@ -4,40 +4,266 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/zenithalgo/api/internal/models"
 )
 
+const (
+	// JobStreamKey is the Redis Stream backtest jobs are dispatched on,
+	// replacing the old zenith:jobs:queue LPUSH list. The {queue} hash tag
+	// keeps it and every other job-related key on the same cluster slot,
+	// so e.g. XAUTOCLAIM's stream+group pairing stays valid under
+	// redis.ModeCluster.
+	JobStreamKey = "zenith:jobs:{queue}:stream"
+	// JobConsumerGroup is the consumer group Python workers read from.
+	JobConsumerGroup = "workers"
+	// JobDLQKey collects jobs JobReaper gave up on after MaxAttempts.
+	JobDLQKey = "zenith:jobs:{queue}:dlq"
+	// JobUpdatesKey is the Pub/Sub channel ResultPersister, WebhookService
+	// and WSHub all subscribe to for job lifecycle events.
+	JobUpdatesKey = "zenith:jobs:updates"
+)
+
+// JobService dispatches backtest jobs onto a Redis Stream for delivery to
+// Python workers, and exposes the consumer group's pending entries and
+// dead-letter queue for operator inspection.
+//
+// Worker contract: a worker reads with
+//
+//	XREADGROUP GROUP workers <consumer> COUNT 1 BLOCK <ms> STREAMS zenith:jobs:{queue}:stream >
+//
+// and must XACK zenith:jobs:{queue}:stream workers <id> once the job has been
+// fully processed (success or failure). This replaces the previous
+// BRPOP zenith:jobs:queue contract. A worker that crashes mid-run simply
+// leaves its entry in the group's pending entries list instead of losing
+// it outright; JobReaper reclaims entries idle past its visibility
+// timeout, re-dispatching them with attempt incremented, or moving them
+// to zenith:jobs:{queue}:dlq once MaxAttempts is exhausted.
 type JobService struct {
-	redis *redis.Client
+	redis redis.UniversalClient
+	state *JobStateStore
+}
+
+func NewJobService(redis redis.UniversalClient) *JobService {
+	s := &JobService{redis: redis, state: NewJobStateStore(redis)}
+	if err := s.ensureGroup(context.Background()); err != nil {
+		log.Printf("JobService: failed to create consumer group: %v", err)
+	}
+	return s
 }
 
-func NewJobService(redis *redis.Client) *JobService {
-	return &JobService{redis: redis}
+// ensureGroup creates the stream (via MKSTREAM) and the workers consumer
+// group if they don't already exist.
+func (s *JobService) ensureGroup(ctx context.Context) error {
+	err := s.redis.XGroupCreateMkStream(ctx, JobStreamKey, JobConsumerGroup, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
 }
 
-// SubmitBacktest 提交回测任务
-func (s *JobService) SubmitBacktest(ctx context.Context, req models.JobRequest) (string, error) {
+// SubmitBacktest 提交回测任务. ownerID is the submitting user's JWT
+// subject (empty when auth is disabled via dev mode); it's stamped onto
+// the job's state document so WSHub can authorize subscribe frames.
+//
+// The initial JobState document and the stream XADD are written in the
+// same MULTI/EXEC (via TxPipelined): if persisting state fails, the
+// enqueue never lands either, so a job never exists on the dispatch
+// stream without a state document a client can poll.
+func (s *JobService) SubmitBacktest(ctx context.Context, req models.JobRequest, ownerID string) (models.JobResponse, error) {
 	jobID := uuid.New().String()
 
-	// 构造 Python Worker 识别的 Payload
-	// 对应 Python 端的 BacktestJob: { job_id: str, config: dict }
-	payload := map[string]interface{}{
-		"job_id": jobID,
-		"config": req.Config,
+	config, err := json.Marshal(req.Config)
+	if err != nil {
+		return models.JobResponse{}, fmt.Errorf("failed to marshal job config: %w", err)
+	}
+
+	submittedAt := time.Now().UTC()
+	state := models.JobState{
+		JobID:       jobID,
+		Owner:       ownerID,
+		Status:      models.JobStatusQueued,
+		Config:      req.Config,
+		SubmittedAt: submittedAt,
+		Metrics:     []map[string]interface{}{},
 	}
 
-	data, err := json.Marshal(payload)
+	var addCmd *redis.StringCmd
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := s.state.queueCreate(ctx, pipe, state); err != nil {
+			return err
+		}
+		// 对应 Python 端的 BacktestJob: { job_id: str, config: dict, attempt: int }
+		addCmd = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: JobStreamKey,
+			Values: map[string]interface{}{
+				"job_id":       jobID,
+				"config":       string(config),
+				"submitted_at": submittedAt.Format(time.RFC3339),
+				"attempt":      0,
+			},
+		})
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal job payload: %w", err)
+		return models.JobResponse{}, fmt.Errorf("failed to submit job: %w", err)
 	}
 
-	// Push 到 Redis 队列 (zenith:jobs:queue)
-	if err := s.redis.LPush(ctx, "zenith:jobs:queue", data).Err(); err != nil {
-		return "", fmt.Errorf("failed to push job to redis: %w", err)
+	return models.JobResponse{JobID: jobID, StreamID: addCmd.Val()}, nil
+}
+
+// GetJob returns jobID's current lifecycle document, or nil if it
+// doesn't exist (never submitted, or its state document expired).
+func (s *JobService) GetJob(ctx context.Context, jobID string) (*models.JobState, error) {
+	return s.state.Get(ctx, jobID)
+}
+
+// State exposes the underlying JobStateStore so other services in this
+// package (WSHub's subscribe authorization) can look up a job's owner
+// without going through JobService's own request/response types.
+func (s *JobService) State() *JobStateStore {
+	return s.state
+}
+
+// ListJobs returns every job state matching filter.
+func (s *JobService) ListJobs(ctx context.Context, filter models.JobStateFilter) ([]models.JobState, error) {
+	return s.state.List(ctx, filter)
+}
+
+// CancelJob marks jobID's state as cancel_requested so whichever worker
+// is running it can notice and abort cooperatively; it does not kill the
+// worker process itself.
+func (s *JobService) CancelJob(ctx context.Context, jobID string) error {
+	return s.state.Cancel(ctx, jobID)
+}
+
+// ListPending returns up to 100 zenith:jobs:{queue}:stream entries currently
+// checked out by a worker consumer but not yet XACKed, restricted to jobs
+// ownerID submitted unless isAdmin is set - matching the authorization
+// GetJob/CancelJob already apply via ownsJob.
+func (s *JobService) ListPending(ctx context.Context, ownerID string, isAdmin bool) ([]models.PendingJobEntry, error) {
+	entries, err := s.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: JobStreamKey,
+		Group:  JobConsumerGroup,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending jobs: %w", err)
 	}
 
-	return jobID, nil
+	out := make([]models.PendingJobEntry, 0, len(entries))
+	for _, e := range entries {
+		msgs, err := s.redis.XRange(ctx, JobStreamKey, e.ID, e.ID).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending entry %s: %w", e.ID, err)
+		}
+		var jobID string
+		if len(msgs) > 0 {
+			jobID, _ = msgs[0].Values["job_id"].(string)
+		}
+		if !isAdmin {
+			state, err := s.state.Get(ctx, jobID)
+			if err != nil {
+				return nil, err
+			}
+			if state == nil || state.Owner != ownerID {
+				continue
+			}
+		}
+		out = append(out, models.PendingJobEntry{
+			StreamID:   e.ID,
+			JobID:      jobID,
+			Consumer:   e.Consumer,
+			Idle:       e.Idle,
+			RetryCount: e.RetryCount,
+		})
+	}
+	return out, nil
+}
+
+// ListDLQ returns every job JobReaper moved to zenith:jobs:{queue}:dlq after
+// exhausting MaxAttempts, restricted to jobs ownerID submitted unless
+// isAdmin is set - matching the authorization GetJob/CancelJob apply.
+func (s *JobService) ListDLQ(ctx context.Context, ownerID string, isAdmin bool) ([]models.DLQEntry, error) {
+	msgs, err := s.redis.XRange(ctx, JobDLQKey, "-", "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dlq: %w", err)
+	}
+
+	out := make([]models.DLQEntry, 0, len(msgs))
+	for _, m := range msgs {
+		entry := dlqEntryFromValues(m.ID, m.Values)
+		if !isAdmin {
+			state, err := s.state.Get(ctx, entry.JobID)
+			if err != nil {
+				return nil, err
+			}
+			if state == nil || state.Owner != ownerID {
+				continue
+			}
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// RequeueDLQ re-submits a dead-lettered job onto the live stream with its
+// attempt counter reset to 0, then removes it from the DLQ.
+func (s *JobService) RequeueDLQ(ctx context.Context, dlqID string) error {
+	msgs, err := s.redis.XRange(ctx, JobDLQKey, dlqID, dlqID).Result()
+	if err != nil {
+		return fmt.Errorf("failed to look up dlq entry: %w", err)
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("dlq entry %s not found", dlqID)
+	}
+	entry := dlqEntryFromValues(msgs[0].ID, msgs[0].Values)
+
+	config, err := json.Marshal(entry.Config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal requeued config: %w", err)
+	}
+	if err := s.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: JobStreamKey,
+		Values: map[string]interface{}{
+			"job_id":       entry.JobID,
+			"config":       string(config),
+			"submitted_at": time.Now().UTC().Format(time.RFC3339),
+			"attempt":      0,
+		},
+	}).Err(); err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+
+	return s.redis.XDel(ctx, JobDLQKey, dlqID).Err()
+}
+
+func dlqEntryFromValues(id string, values map[string]interface{}) models.DLQEntry {
+	entry := models.DLQEntry{StreamID: id}
+	if v, ok := values["job_id"].(string); ok {
+		entry.JobID = v
+	}
+	if v, ok := values["config"].(string); ok {
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(v), &config); err == nil {
+			entry.Config = config
+		}
+	}
+	if v, ok := values["attempt"].(string); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			entry.Attempt = n
+		}
+	}
+	if v, ok := values["reason"].(string); ok {
+		entry.Reason = v
+	}
+	return entry
 }
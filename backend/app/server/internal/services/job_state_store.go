@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zenithalgo/api/internal/models"
+)
+
+// jobStateKeyPrefix carries the {queue} cluster hash tag: every job's
+// state key lands on the same slot as the stream/dlq keys (see
+// JobStreamKey), so SubmitBacktest can write the state and XADD the
+// stream entry in one MULTI/EXEC. job_id stays outside the tag.
+const jobStateKeyPrefix = "zenith:jobs:{queue}:state:"
+const jobStateKeySuffix = ""
+
+// JobStateStore persists per-job lifecycle documents (status, progress,
+// metrics, cancellation) so clients can poll a submitted job instead of
+// only ever hearing about it once on zenith:jobs:updates.
+//
+// It prefers RedisJSON (JSON.SET/JSON.GET/JSON.ARRAPPEND, issued through
+// the generic Do path since go-redis has no typed RedisJSON commands)
+// and falls back to an HSET-encoded document with the same interface
+// when the `ReJSON` module isn't loaded, detected once at startup via
+// MODULE LIST.
+type JobStateStore struct {
+	redis        redis.UniversalClient
+	hasRedisJSON bool
+}
+
+func NewJobStateStore(rdb redis.UniversalClient) *JobStateStore {
+	return &JobStateStore{redis: rdb, hasRedisJSON: detectRedisJSON(rdb)}
+}
+
+func detectRedisJSON(rdb redis.UniversalClient) bool {
+	res, err := rdb.Do(context.Background(), "MODULE", "LIST").Result()
+	if err != nil {
+		return false
+	}
+	modules, ok := res.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, m := range modules {
+		fields, ok := m.([]interface{})
+		if !ok {
+			continue
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			name, _ := fields[i].(string)
+			if !strings.EqualFold(name, "name") {
+				continue
+			}
+			if v, _ := fields[i+1].(string); strings.EqualFold(v, "ReJSON") || strings.EqualFold(v, "rejson") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stateKey(jobID string) string {
+	return jobStateKeyPrefix + jobID + jobStateKeySuffix
+}
+
+// queueCreate queues the initial document write for state.JobID onto
+// cmdable without executing it, so JobService.SubmitBacktest can bundle
+// it into the same MULTI/EXEC as the stream XADD: either both land or
+// neither does.
+func (s *JobStateStore) queueCreate(ctx context.Context, cmdable redis.Cmdable, state models.JobState) error {
+	if s.hasRedisJSON {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job state: %w", err)
+		}
+		cmdable.Do(ctx, "JSON.SET", stateKey(state.JobID), "$", string(data))
+		return nil
+	}
+
+	fields, err := stateHashFields(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode job state: %w", err)
+	}
+	cmdable.HSet(ctx, stateKey(state.JobID), fields)
+	return nil
+}
+
+// Get returns the job's current state, or nil if no such job exists.
+func (s *JobStateStore) Get(ctx context.Context, jobID string) (*models.JobState, error) {
+	if s.hasRedisJSON {
+		raw, err := s.redis.Do(ctx, "JSON.GET", stateKey(jobID)).Text()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to get job state: %w", err)
+		}
+		var state models.JobState
+		if err := json.Unmarshal([]byte(raw), &state); err != nil {
+			return nil, fmt.Errorf("failed to decode job state: %w", err)
+		}
+		return &state, nil
+	}
+
+	fields, err := s.redis.HGetAll(ctx, stateKey(jobID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job state: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return decodeStateHash(fields)
+}
+
+// List scans every zenith:jobs:{queue}:state:* document and returns the
+// ones matching filter. SCAN only covers the node it's issued against;
+// all state keys share the {queue} slot, so in redis.ModeCluster this
+// finds them only when the scan reaches the master owning that slot.
+func (s *JobStateStore) List(ctx context.Context, filter models.JobStateFilter) ([]models.JobState, error) {
+	var (
+		cursor uint64
+		keys   []string
+	)
+	pattern := jobStateKeyPrefix + "*" + jobStateKeySuffix
+	for {
+		batch, next, err := s.redis.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job state keys: %w", err)
+		}
+		keys = append(keys, batch...)
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	out := make([]models.JobState, 0, len(keys))
+	for _, key := range keys {
+		jobID := strings.TrimSuffix(strings.TrimPrefix(key, jobStateKeyPrefix), jobStateKeySuffix)
+		state, err := s.Get(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if state == nil {
+			continue
+		}
+		if filter.Status != "" && state.Status != filter.Status {
+			continue
+		}
+		if filter.FilterByOwner && state.Owner != filter.Owner {
+			continue
+		}
+		out = append(out, *state)
+	}
+	return out, nil
+}
+
+// Cancel sets cancel_requested=true so the worker running jobID notices
+// on its next cooperative check and aborts.
+func (s *JobStateStore) Cancel(ctx context.Context, jobID string) error {
+	if s.hasRedisJSON {
+		return s.redis.Do(ctx, "JSON.SET", stateKey(jobID), "$.cancel_requested", "true").Err()
+	}
+	return s.redis.HSet(ctx, stateKey(jobID), "cancel_requested", "true").Err()
+}
+
+// UpdateProgress overwrites only the progress sub-document, so a worker
+// reporting tick-by-tick equity curve progress doesn't have to resend
+// config/metrics/etc. on every update.
+func (s *JobStateStore) UpdateProgress(ctx context.Context, jobID string, progress models.JobProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress: %w", err)
+	}
+	if s.hasRedisJSON {
+		return s.redis.Do(ctx, "JSON.SET", stateKey(jobID), "$.progress", string(data)).Err()
+	}
+	return s.redis.HSet(ctx, stateKey(jobID), "progress", string(data)).Err()
+}
+
+// AppendMetric appends one metric sample to the job's metrics array.
+// Under RedisJSON this is a single JSON.ARRAPPEND; the HSET fallback has
+// no equivalent append primitive, so it falls back to a WATCH-guarded
+// read-modify-write instead.
+func (s *JobStateStore) AppendMetric(ctx context.Context, jobID string, metric map[string]interface{}) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+	if s.hasRedisJSON {
+		return s.redis.Do(ctx, "JSON.ARRAPPEND", stateKey(jobID), "$.metrics", string(data)).Err()
+	}
+
+	key := stateKey(jobID)
+	return s.redis.Watch(ctx, func(tx *redis.Tx) error {
+		raw, err := tx.HGet(ctx, key, "metrics").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		var metrics []map[string]interface{}
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &metrics); err != nil {
+				return err
+			}
+		}
+		metrics = append(metrics, metric)
+		updated, err := json.Marshal(metrics)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "metrics", string(updated))
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func stateHashFields(state models.JobState) (map[string]interface{}, error) {
+	configJSON, err := json.Marshal(state.Config)
+	if err != nil {
+		return nil, err
+	}
+	progressJSON, err := json.Marshal(state.Progress)
+	if err != nil {
+		return nil, err
+	}
+	metricsJSON, err := json.Marshal(state.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{
+		"job_id":           state.JobID,
+		"owner":            state.Owner,
+		"status":           string(state.Status),
+		"config":           string(configJSON),
+		"submitted_at":     state.SubmittedAt.Format(time.RFC3339),
+		"progress":         string(progressJSON),
+		"metrics":          string(metricsJSON),
+		"error":            state.Error,
+		"cancel_requested": strconv.FormatBool(state.CancelRequested),
+	}
+	if state.StartedAt != nil {
+		fields["started_at"] = state.StartedAt.Format(time.RFC3339)
+	}
+	if state.FinishedAt != nil {
+		fields["finished_at"] = state.FinishedAt.Format(time.RFC3339)
+	}
+	return fields, nil
+}
+
+func decodeStateHash(fields map[string]string) (*models.JobState, error) {
+	state := &models.JobState{
+		JobID:           fields["job_id"],
+		Owner:           fields["owner"],
+		Status:          models.JobStatus(fields["status"]),
+		Error:           fields["error"],
+		CancelRequested: fields["cancel_requested"] == "true",
+	}
+	if v := fields["config"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &state.Config); err != nil {
+			return nil, fmt.Errorf("failed to decode config: %w", err)
+		}
+	}
+	if v := fields["progress"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &state.Progress); err != nil {
+			return nil, fmt.Errorf("failed to decode progress: %w", err)
+		}
+	}
+	if v := fields["metrics"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &state.Metrics); err != nil {
+			return nil, fmt.Errorf("failed to decode metrics: %w", err)
+		}
+	}
+	if v := fields["submitted_at"]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			state.SubmittedAt = t
+		}
+	}
+	if v := fields["started_at"]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			state.StartedAt = &t
+		}
+	}
+	if v := fields["finished_at"]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			state.FinishedAt = &t
+		}
+	}
+	return state, nil
+}
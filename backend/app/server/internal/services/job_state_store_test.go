@@ -0,0 +1,117 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zenithalgo/api/internal/models"
+)
+
+// TestStateHashRoundTrip exercises the HSET-fallback encode/decode path in
+// isolation (no Redis connection needed): stateHashFields is what
+// queueCreate writes when RedisJSON isn't loaded, and decodeStateHash is
+// what Get/List read back, so a mismatch here silently corrupts every job
+// a JobStateStore without the ReJSON module serves.
+func TestStateHashRoundTrip(t *testing.T) {
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	finishedAt := startedAt.Add(5 * time.Minute)
+
+	cases := []struct {
+		name  string
+		state models.JobState
+	}{
+		{
+			name: "full lifecycle document",
+			state: models.JobState{
+				JobID:           "job-1",
+				Owner:           "user-1",
+				Status:          models.JobStatusSucceeded,
+				Config:          map[string]interface{}{"symbol": "BTCUSDT", "leverage": float64(3)},
+				SubmittedAt:     startedAt.Add(-time.Minute),
+				StartedAt:       &startedAt,
+				FinishedAt:      &finishedAt,
+				Progress:        models.JobProgress{Pct: 100, Stage: "done"},
+				Metrics:         []map[string]interface{}{{"sharpe": 1.5}, {"max_drawdown": -0.2}},
+				Error:           "",
+				CancelRequested: false,
+			},
+		},
+		{
+			name: "queued, no started/finished, no metrics",
+			state: models.JobState{
+				JobID:           "job-2",
+				Owner:           "",
+				Status:          models.JobStatusQueued,
+				Config:          map[string]interface{}{},
+				SubmittedAt:     startedAt,
+				Progress:        models.JobProgress{},
+				Metrics:         nil,
+				CancelRequested: true,
+			},
+		},
+		{
+			name: "failed with error message",
+			state: models.JobState{
+				JobID:       "job-3",
+				Owner:       "user-2",
+				Status:      models.JobStatusFailed,
+				Config:      map[string]interface{}{"symbol": "ETHUSDT"},
+				SubmittedAt: startedAt,
+				StartedAt:   &startedAt,
+				FinishedAt:  &finishedAt,
+				Error:       "worker crashed: OOM",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			fields, err := stateHashFields(tc.state)
+			if err != nil {
+				t.Fatalf("stateHashFields: %v", err)
+			}
+
+			// decodeStateHash takes map[string]string, matching what
+			// redis.Cmdable.HGetAll returns - stringify fields the same
+			// way an HSet call would coerce them.
+			strFields := make(map[string]string, len(fields))
+			for k, v := range fields {
+				s, ok := v.(string)
+				if !ok {
+					t.Fatalf("field %q is %T, want string", k, v)
+				}
+				strFields[k] = s
+			}
+
+			got, err := decodeStateHash(strFields)
+			if err != nil {
+				t.Fatalf("decodeStateHash: %v", err)
+			}
+
+			if !reflect.DeepEqual(*got, tc.state) {
+				t.Errorf("round trip mismatch:\n got:  %+v\n want: %+v", *got, tc.state)
+			}
+		})
+	}
+}
+
+// TestDecodeStateHashEmptyOptionalFields confirms decodeStateHash leaves
+// StartedAt/FinishedAt nil rather than pointing at the zero time when
+// those hash fields were never written (queued jobs never ran yet).
+func TestDecodeStateHashEmptyOptionalFields(t *testing.T) {
+	got, err := decodeStateHash(map[string]string{
+		"job_id": "job-4",
+		"status": string(models.JobStatusQueued),
+	})
+	if err != nil {
+		t.Fatalf("decodeStateHash: %v", err)
+	}
+	if got.StartedAt != nil {
+		t.Errorf("StartedAt = %v, want nil", got.StartedAt)
+	}
+	if got.FinishedAt != nil {
+		t.Errorf("FinishedAt = %v, want nil", got.FinishedAt)
+	}
+}
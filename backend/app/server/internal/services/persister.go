@@ -4,22 +4,67 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"github.com/zenithalgo/api/internal/models"
 )
 
+const (
+	defaultIngestWorkers   = 4
+	defaultIngestQueueSize = 256
+)
+
+var (
+	ingestQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "zenith_ingest_queue_depth",
+		Help: "Current number of parsed job results waiting to be written to Postgres.",
+	})
+	ingestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zenith_ingest_duration_seconds",
+		Help:    "Time to write a single job result (backtest + trades + equity) to Postgres.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(ingestQueueDepth, ingestDuration)
+}
+
+// ingestJob is a fully parsed job result waiting to be written to Postgres.
+type ingestJob struct {
+	result *models.BacktestResult
+	trades []models.Trade
+	equity []models.EquityPoint
+}
+
+// ResultPersister subscribes to zenith:jobs:updates and writes completed
+// job results to Postgres. Parsing happens inline on the subscriber
+// goroutine, but the write itself goes through a bounded worker pool: a
+// burst of completed jobs queues up to queueSize before listenLoop blocks
+// on enqueue, instead of spawning one goroutine per job.
 type ResultPersister struct {
-	redis           *redis.Client
+	redis           redis.UniversalClient
 	backtestService *BacktestService
+	queue           chan ingestJob
 }
 
-func NewResultPersister(rdb *redis.Client, bs *BacktestService) *ResultPersister {
-	return &ResultPersister{
+func NewResultPersister(rdb redis.UniversalClient, bs *BacktestService) *ResultPersister {
+	workers := envInt("ZENITH_INGEST_WORKERS", defaultIngestWorkers)
+	queueSize := envInt("ZENITH_INGEST_QUEUE_SIZE", defaultIngestQueueSize)
+
+	p := &ResultPersister{
 		redis:           rdb,
 		backtestService: bs,
+		queue:           make(chan ingestJob, queueSize),
 	}
+	for i := 0; i < workers; i++ {
+		go p.ingestWorker()
+	}
+	return p
 }
 
 func (p *ResultPersister) Start() {
@@ -28,7 +73,7 @@ func (p *ResultPersister) Start() {
 
 func (p *ResultPersister) listenLoop() {
 	ctx := context.Background()
-	pubsub := p.redis.Subscribe(ctx, "zenith:jobs:updates")
+	pubsub := p.redis.Subscribe(ctx, JobUpdatesKey)
 	defer pubsub.Close()
 
 	ch := pubsub.Channel()
@@ -40,25 +85,54 @@ func (p *ResultPersister) listenLoop() {
 		}
 
 		if data["type"] == "success" {
-			log.Printf("Persister: Received success for job %v, saving...", data["job_id"])
-			p.handleSuccess(data)
+			log.Printf("Persister: Received success for job %v, queueing for ingest...", data["job_id"])
+			result, trades, equity := ParsePayload(data)
+
+			// Blocks once queueSize results are pending, which throttles
+			// this subscriber loop rather than piling up goroutines.
+			p.queue <- ingestJob{result: result, trades: trades, equity: equity}
+			ingestQueueDepth.Set(float64(len(p.queue)))
 		}
 	}
 }
 
-func (p *ResultPersister) handleSuccess(data map[string]interface{}) {
-	// Parse Summary
-	summary, ok := data["summary"].(map[string]interface{})
-	if !ok {
-		log.Println("Persister: summary missing or invalid")
-		return
+func (p *ResultPersister) ingestWorker() {
+	for job := range p.queue {
+		start := time.Now()
+		if err := p.backtestService.SaveResult(context.Background(), job.result, job.trades, job.equity); err != nil {
+			log.Printf("Persister: Failed to save result: %v", err)
+		} else {
+			log.Printf("Persister: Result saved successfully for job %v", job.result.RunID)
+		}
+		ingestDuration.Observe(time.Since(start).Seconds())
+		ingestQueueDepth.Set(float64(len(p.queue)))
+	}
+}
+
+func envInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultValue
 	}
+	return n
+}
+
+// ParsePayload maps a raw `zenith:jobs:updates` success payload onto the
+// persistence model. It's split out of the persister so the conformance
+// harness in internal/services/conformance can replay fixtures against it
+// without a live Redis connection or database.
+func ParsePayload(data map[string]interface{}) (*models.BacktestResult, []models.Trade, []models.EquityPoint) {
+	summary, _ := data["summary"].(map[string]interface{})
 
 	// 1. Map BacktestResult
 	metrics, _ := summary["metrics"].(map[string]interface{})
 
 	result := &models.BacktestResult{
-		RunID:        data["job_id"].(string), // Use job_id as run_id
+		RunID:        getString(data, "job_id"),
 		Symbol:       getString(summary["data_health"], "symbol"),
 		Timeframe:    getString(summary["data_health"], "interval"),
 		StartDate:    parseTime(getString(summary["data_health"], "start")),
@@ -80,7 +154,10 @@ func (p *ResultPersister) handleSuccess(data map[string]interface{}) {
 	var trades []models.Trade
 	if rawTrades, ok := summary["trades"].([]interface{}); ok {
 		for _, t := range rawTrades {
-			tm := t.(map[string]interface{})
+			tm, ok := t.(map[string]interface{})
+			if !ok {
+				continue
+			}
 			trades = append(trades, models.Trade{
 				Timestamp:     parseTime(getString(tm, "ts")),
 				Symbol:        getString(tm, "symbol"),
@@ -99,7 +176,10 @@ func (p *ResultPersister) handleSuccess(data map[string]interface{}) {
 	if rawEq, ok := summary["equity_curve"].([]interface{}); ok {
 		peak := -1e9
 		for _, e := range rawEq {
-			em := e.(map[string]interface{})
+			em, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
 			eqVal := getFloat(em, "equity")
 			if eqVal > peak {
 				peak = eqVal
@@ -119,12 +199,7 @@ func (p *ResultPersister) handleSuccess(data map[string]interface{}) {
 		}
 	}
 
-	// Save
-	if err := p.backtestService.SaveResult(result, trades, equity); err != nil {
-		log.Printf("Persister: Failed to save result: %v", err)
-	} else {
-		log.Printf("Persister: Result saved successfully for job %v", result.RunID)
-	}
+	return result, trades, equity
 }
 
 // Helpers
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRateLimitQPS   = 5.0
+	defaultRateLimitBurst = 10
+)
+
+// gcraScript implements the Generic Cell Rate Algorithm as a single Lua
+// script so the read-check-write of the per-key rate stays atomic under
+// concurrent requests without a WATCH/retry loop. KEYS[1] is the limit
+// key; ARGV is (emission interval in ms, burst, now in ms, cost).
+//
+// It stores only the theoretical arrival time (TAT) of the next allowed
+// request, which is all GCRA needs - no separate counter/window bookkeeping.
+var gcraScript = redis.NewScript(`
+local key = KEYS[1]
+local emission_interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local burst_offset = emission_interval * burst
+local tat = tonumber(redis.call("GET", key))
+if tat == nil then
+  tat = now
+end
+tat = math.max(tat, now)
+
+local new_tat = tat + emission_interval * cost
+local allow_at = new_tat - burst_offset
+if allow_at > now then
+  local ttl = math.ceil((tat - now) / 1000) + 1
+  return {0, ttl}
+end
+
+local ttl = math.ceil((new_tat - now) / 1000) + 1
+redis.call("SET", key, new_tat, "PX", ttl * 1000)
+return {1, 0}
+`)
+
+// RateLimiter is a Redis-backed GCRA token bucket shared across server
+// instances, keyed per caller (e.g. per authenticated user). It's applied
+// to both SubmitBacktest and the WS subscribe op so a single bad actor
+// can't starve either path.
+type RateLimiter struct {
+	redis  redis.UniversalClient
+	qps    float64
+	burst  int
+	prefix string
+}
+
+// NewRateLimiter builds a limiter allowing qps requests/second sustained,
+// with up to burst requests admitted instantly. prefix namespaces the
+// Redis keys (e.g. "http" vs "ws") so two limiters never collide on the
+// same caller key.
+func NewRateLimiter(rdb redis.UniversalClient, qps float64, burst int, prefix string) *RateLimiter {
+	return &RateLimiter{redis: rdb, qps: qps, burst: burst, prefix: prefix}
+}
+
+// Allow reports whether the caller identified by key may proceed now,
+// consuming one token if so.
+func (r *RateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	emissionIntervalMs := 1000.0 / r.qps
+	now := time.Now().UnixMilli()
+
+	res, err := gcraScript.Run(ctx, r.redis, []string{r.redisKey(key)}, emissionIntervalMs, r.burst, now, 1).Result()
+	if err != nil {
+		return false, fmt.Errorf("rate limiter: %w", err)
+	}
+
+	fields, ok := res.([]interface{})
+	if !ok || len(fields) == 0 {
+		return false, fmt.Errorf("rate limiter: unexpected script result %v", res)
+	}
+	allowed, _ := fields[0].(int64)
+	return allowed == 1, nil
+}
+
+func (r *RateLimiter) redisKey(key string) string {
+	return "zenith:ratelimit:" + r.prefix + ":" + key
+}
+
+// NewRateLimiterFromEnv builds a RateLimiter for the given prefix ("http",
+// "ws"), reading ZENITH_RATELIMIT_<PREFIX>_QPS / ZENITH_RATELIMIT_<PREFIX>_BURST
+// so SubmitBacktest and the WS subscribe op can be tuned independently.
+func NewRateLimiterFromEnv(rdb redis.UniversalClient, prefix string) *RateLimiter {
+	upper := strings.ToUpper(prefix)
+	qps := envFloat("ZENITH_RATELIMIT_"+upper+"_QPS", defaultRateLimitQPS)
+	burst := envInt("ZENITH_RATELIMIT_"+upper+"_BURST", defaultRateLimitBurst)
+	return NewRateLimiter(rdb, qps, burst, prefix)
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return f
+}
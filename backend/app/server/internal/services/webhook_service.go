@@ -0,0 +1,268 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/zenithalgo/api/internal/models"
+)
+
+// webhookRetryBackoff is the fixed retry schedule for failed deliveries.
+var webhookRetryBackoff = []time.Duration{
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+}
+
+const webhookWorkerCount = 8
+
+// delivery is an in-flight unit of work fed to the worker pool.
+type delivery struct {
+	webhook models.Webhook
+	eventID string
+	event   models.WebhookEvent
+	payload []byte
+	attempt int
+}
+
+// WebhookService fans backtest job lifecycle events out to registered
+// HTTP subscribers, in parallel with ResultPersister's Postgres writes.
+type WebhookService struct {
+	db     *sqlx.DB
+	redis  redis.UniversalClient
+	client *http.Client
+	jobs   chan delivery
+}
+
+func NewWebhookService(db *sqlx.DB, rdb redis.UniversalClient) *WebhookService {
+	s := &WebhookService{
+		db:     db,
+		redis:  rdb,
+		client: &http.Client{Timeout: 10 * time.Second},
+		jobs:   make(chan delivery, 256),
+	}
+	for i := 0; i < webhookWorkerCount; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Start subscribes to zenith:jobs:updates alongside ResultPersister.
+func (s *WebhookService) Start() {
+	go s.listenLoop()
+}
+
+func (s *WebhookService) listenLoop() {
+	ctx := context.Background()
+	pubsub := s.redis.Subscribe(ctx, JobUpdatesKey)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for msg := range ch {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
+			log.Printf("WebhookService: failed to unmarshal msg: %v", err)
+			continue
+		}
+		eventType, _ := data["type"].(string)
+		if eventType == "" {
+			continue
+		}
+		s.dispatch(models.WebhookEvent(eventType), []byte(msg.Payload))
+	}
+}
+
+// dispatch fans the raw event payload out to every subscriber whose mask
+// matches, enqueueing one delivery per match onto the worker pool.
+func (s *WebhookService) dispatch(event models.WebhookEvent, payload []byte) {
+	var hooks []models.Webhook
+	if err := s.db.Select(&hooks, `SELECT id, url, secret, events, created_at, last_delivery, failure_count FROM webhooks`); err != nil {
+		log.Printf("WebhookService: failed to load subscriptions: %v", err)
+		return
+	}
+
+	eventID := uuid.New().String()
+	for _, hook := range hooks {
+		if !hook.Events.Has(event) {
+			continue
+		}
+		s.jobs <- delivery{webhook: hook, eventID: eventID, event: event, payload: payload, attempt: 1}
+	}
+}
+
+func (s *WebhookService) worker() {
+	for job := range s.jobs {
+		s.attemptDelivery(job)
+	}
+}
+
+func (s *WebhookService) attemptDelivery(job delivery) {
+	statusCode, deliveryErr := s.send(job)
+
+	delivered := deliveryErr == nil && statusCode >= 200 && statusCode < 300
+	errMsg := ""
+	if deliveryErr != nil {
+		errMsg = deliveryErr.Error()
+	}
+
+	var nextRetry *time.Time
+	if !delivered && job.attempt <= len(webhookRetryBackoff) {
+		t := time.Now().Add(webhookRetryBackoff[job.attempt-1])
+		nextRetry = &t
+	}
+
+	if err := s.recordAttempt(job, statusCode, errMsg, delivered, nextRetry); err != nil {
+		log.Printf("WebhookService: failed to record delivery attempt: %v", err)
+	}
+
+	if delivered {
+		s.markSuccess(job.webhook.ID)
+		return
+	}
+
+	s.markFailure(job.webhook.ID)
+	if job.attempt <= len(webhookRetryBackoff) {
+		next := job
+		next.attempt++
+		delay := webhookRetryBackoff[job.attempt-1]
+		time.AfterFunc(delay, func() {
+			s.jobs <- next
+		})
+	}
+}
+
+func (s *WebhookService) send(job delivery) (int, error) {
+	sig := sign(job.webhook.Secret, job.payload)
+
+	req, err := http.NewRequest(http.MethodPost, job.webhook.URL, bytes.NewReader(job.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Zenith-Signature", sig)
+	req.Header.Set("X-Zenith-Event-Id", job.eventID)
+	req.Header.Set("X-Zenith-Timestamp", time.Now().UTC().Format(time.RFC3339))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of payload using secret.
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) recordAttempt(job delivery, statusCode int, errMsg string, delivered bool, nextRetry *time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, attempt, status_code, error, delivered, created_at, next_retry_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`,
+		job.webhook.ID, job.eventID, string(job.event), string(job.payload),
+		job.attempt, statusCode, errMsg, delivered, time.Now(), nextRetry,
+	)
+	return err
+}
+
+func (s *WebhookService) markSuccess(webhookID int) {
+	if _, err := s.db.Exec(`UPDATE webhooks SET last_delivery = $1, failure_count = 0 WHERE id = $2`, time.Now(), webhookID); err != nil {
+		log.Printf("WebhookService: failed to update webhook %d after success: %v", webhookID, err)
+	}
+}
+
+func (s *WebhookService) markFailure(webhookID int) {
+	if _, err := s.db.Exec(`UPDATE webhooks SET failure_count = failure_count + 1 WHERE id = $1`, webhookID); err != nil {
+		log.Printf("WebhookService: failed to update webhook %d after failure: %v", webhookID, err)
+	}
+}
+
+// CreateWebhook inserts a new subscription and returns it with a freshly
+// generated secret.
+func (s *WebhookService) CreateWebhook(req models.CreateWebhookRequest) (*models.Webhook, error) {
+	events := make(models.EventMask, len(req.Events))
+	for i, e := range req.Events {
+		events[i] = models.WebhookEvent(e)
+	}
+
+	hook := &models.Webhook{
+		URL:       req.URL,
+		Secret:    uuid.New().String(),
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.db.QueryRowx(`
+		INSERT INTO webhooks (url, secret, events, created_at, failure_count)
+		VALUES ($1, $2, $3, $4, 0) RETURNING id
+	`, hook.URL, hook.Secret, hook.Events, hook.CreatedAt).Scan(&hook.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// ListWebhooks returns every registered subscription.
+func (s *WebhookService) ListWebhooks() ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := s.db.Select(&hooks, `SELECT id, url, secret, events, created_at, last_delivery, failure_count FROM webhooks ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook removes a subscription by id.
+func (s *WebhookService) DeleteWebhook(id int) error {
+	_, err := s.db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// RotateSecret issues a new HMAC secret for a subscription.
+func (s *WebhookService) RotateSecret(id int) (string, error) {
+	secret := uuid.New().String()
+	_, err := s.db.Exec(`UPDATE webhooks SET secret = $1 WHERE id = $2`, secret, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate secret: %w", err)
+	}
+	return secret, nil
+}
+
+// TestFire sends a synthetic event to a single webhook, bypassing the
+// subscription mask, so operators can verify an endpoint without waiting
+// for a real job.
+func (s *WebhookService) TestFire(id int) error {
+	var hook models.Webhook
+	err := s.db.Get(&hook, `SELECT id, url, secret, events, created_at, last_delivery, failure_count FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("webhook not found: %w", err)
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":    "test",
+		"job_id":  "test-fire",
+		"message": "this is a test delivery from ZenithAlgo",
+	})
+	s.jobs <- delivery{webhook: hook, eventID: uuid.New().String(), event: "test", payload: payload, attempt: 1}
+	return nil
+}
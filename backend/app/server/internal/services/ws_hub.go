@@ -2,71 +2,184 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// clientSendBuffer bounds how far a slow client can lag before it gets
+	// dropped instead of blocking the broadcast loop.
+	clientSendBuffer = 64
+	writeWait        = 10 * time.Second
+	pingInterval     = 30 * time.Second
+	pongWait         = 60 * time.Second
+
+	// jobEventsPattern is PSUBSCRIBEd so a worker's
+	// `PUBLISH zenith:jobs:events:{job_id} {json}` reaches only the WS
+	// clients that asked for that job_id, instead of every client.
+	jobEventsPattern = "zenith:jobs:events:*"
+	jobEventsPrefix  = "zenith:jobs:events:"
+
+	// allJobsWildcard is the job_id an admin client subscribes with to
+	// receive every zenith:jobs:events:* message, for monitoring.
+	allJobsWildcard = "*"
+)
+
+var (
+	wsConnectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "zenith_ws_connected_clients",
+		Help: "Number of currently connected WebSocket clients.",
+	})
+	wsDroppedSlowClients = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "zenith_ws_dropped_slow_clients_total",
+		Help: "Number of clients disconnected for falling behind on broadcast.",
+	})
+	wsBroadcastQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "zenith_ws_broadcast_queue_depth",
+		Help: "Current depth of the hub's broadcast channel.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(wsConnectedClients, wsDroppedSlowClients, wsBroadcastQueueDepth)
+}
+
+// client wraps a single WebSocket connection with its own send queue, so
+// one slow reader can't block the broadcast loop or any other client.
+// subs tracks the job_ids this connection asked to follow via
+// {"op":"subscribe","job_id":"..."} frames. userID and isAdmin come from
+// the ticket the connection upgraded with and gate which job_ids it's
+// allowed to subscribe to.
+type client struct {
+	conn    *websocket.Conn
+	send    chan []byte
+	userID  string
+	isAdmin bool
+	subsMu  sync.Mutex
+	subs    map[string]struct{}
+}
+
+func (c *client) subscribe(jobID string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[jobID] = struct{}{}
+}
+
+func (c *client) unsubscribe(jobID string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.subs, jobID)
+}
+
+func (c *client) subscribedTo(jobID string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	_, ok := c.subs[jobID]
+	return ok
+}
+
+// rateLimitKey identifies this connection for the subscribe-op rate
+// limiter: the authenticated user id when there is one, falling back to
+// the remote address so anonymous/dev-mode connections don't all share a
+// single global bucket.
+func (c *client) rateLimitKey() string {
+	if c.userID != "" {
+		return c.userID
+	}
+	return c.conn.RemoteAddr().String()
+}
+
 type WSHub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan []byte
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	redis      *redis.Client
-	mu         sync.Mutex
+	clients     map[*client]bool
+	broadcast   chan []byte
+	register    chan *client
+	unregister  chan *client
+	redis       redis.UniversalClient
+	jobRedis    redis.UniversalClient
+	state       *JobStateStore
+	rateLimiter *RateLimiter
+	mu          sync.Mutex
 }
 
-func NewWSHub(rdb *redis.Client) *WSHub {
+// NewWSHub takes two Redis clients: redis serves zenith:jobs:updates
+// broadcast and everything else the hub needs, while jobRedis is
+// dedicated to the zenith:jobs:events:* PSUBSCRIBE below. Pub/Sub pins a
+// connection for as long as the subscription lives, so it gets its own
+// client instead of sharing the pool with ordinary commands.
+//
+// state authorizes per-job subscribe frames against the job's owner (see
+// readPump), and rateLimiter throttles how often one connection can send
+// subscribe frames.
+func NewWSHub(rdb redis.UniversalClient, jobRedis redis.UniversalClient, state *JobStateStore, rateLimiter *RateLimiter) *WSHub {
 	return &WSHub{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
-		redis:      rdb,
+		clients:     make(map[*client]bool),
+		broadcast:   make(chan []byte, 256),
+		register:    make(chan *client),
+		unregister:  make(chan *client),
+		redis:       rdb,
+		jobRedis:    jobRedis,
+		state:       state,
+		rateLimiter: rateLimiter,
 	}
 }
 
 func (h *WSHub) Run() {
-	// Start Redis Subscriber in background
+	// Start Redis Subscribers in background
 	go h.subscribeRedis()
+	go h.subscribeJobEvents()
 
 	for {
 		select {
-		case client := <-h.register:
+		case c := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = true
+			h.clients[c] = true
 			h.mu.Unlock()
+			wsConnectedClients.Set(float64(len(h.clients)))
 			log.Println("WS: Client connected")
 
-		case client := <-h.unregister:
+		case c := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				client.Close()
-				log.Println("WS: Client disconnected")
+			if _, ok := h.clients[c]; ok {
+				delete(h.clients, c)
+				close(c.send)
 			}
 			h.mu.Unlock()
+			wsConnectedClients.Set(float64(len(h.clients)))
+			log.Println("WS: Client disconnected")
 
 		case message := <-h.broadcast:
+			wsBroadcastQueueDepth.Set(float64(len(h.broadcast)))
 			h.mu.Lock()
-			for client := range h.clients {
-				err := client.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					log.Printf("WS: Write error: %v, closing client", err)
-					client.Close()
-					delete(h.clients, client)
+			for c := range h.clients {
+				select {
+				case c.send <- message:
+				default:
+					// Slow client: drop it rather than block everyone else.
+					log.Println("WS: dropping slow client, send buffer full")
+					delete(h.clients, c)
+					close(c.send)
+					wsDroppedSlowClients.Inc()
 				}
 			}
 			h.mu.Unlock()
+			wsConnectedClients.Set(float64(len(h.clients)))
 		}
 	}
 }
 
 func (h *WSHub) subscribeRedis() {
 	ctx := context.Background()
-	pubsub := h.redis.Subscribe(ctx, "zenith:jobs:updates")
+	pubsub := h.redis.Subscribe(ctx, JobUpdatesKey)
 	defer pubsub.Close()
 
 	ch := pubsub.Channel()
@@ -76,11 +189,165 @@ func (h *WSHub) subscribeRedis() {
 	}
 }
 
-// 辅助结构，用于 WS Handler 调用
-func (h *WSHub) RegisterClient(conn *websocket.Conn) {
-	h.register <- conn
+// subscribeJobEvents fans zenith:jobs:events:{job_id} messages out to only
+// the connections that subscribed to that job_id, as opposed to
+// subscribeRedis's broadcast-to-everyone zenith:jobs:updates channel.
+func (h *WSHub) subscribeJobEvents() {
+	ctx := context.Background()
+	pubsub := h.jobRedis.PSubscribe(ctx, jobEventsPattern)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for msg := range ch {
+		jobID := strings.TrimPrefix(msg.Channel, jobEventsPrefix)
+		h.fanOutJobEvent(jobID, []byte(msg.Payload))
+	}
+}
+
+func (h *WSHub) fanOutJobEvent(jobID string, payload []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if !c.subscribedTo(jobID) && !c.subscribedTo(allJobsWildcard) {
+			continue
+		}
+		select {
+		case c.send <- payload:
+		default:
+			log.Println("WS: dropping slow client, send buffer full")
+			delete(h.clients, c)
+			close(c.send)
+			wsDroppedSlowClients.Inc()
+		}
+	}
+}
+
+// RegisterClient adopts conn into the hub and starts its reader/writer
+// pumps. The caller no longer needs to read from conn itself. userID and
+// isAdmin come from the ticket the caller validated before upgrading.
+func (h *WSHub) RegisterClient(conn *websocket.Conn, userID string, isAdmin bool) {
+	c := &client{conn: conn, send: make(chan []byte, clientSendBuffer), userID: userID, isAdmin: isAdmin}
+	h.register <- c
+
+	go h.writePump(c)
+	go h.readPump(c)
 }
 
-func (h *WSHub) UnregisterClient(conn *websocket.Conn) {
-	h.unregister <- conn
+// writePump owns all writes to conn: broadcast messages plus periodic
+// pings, each under its own write deadline.
+func (h *WSHub) writePump(c *client) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("WS: write error: %v", err)
+				h.unregister <- c
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("WS: ping error: %v", err)
+				h.unregister <- c
+				return
+			}
+		}
+	}
+}
+
+// clientFrame is a control message a client sends over the WS connection
+// to manage its own zenith:jobs:events:{job_id} subscriptions.
+type clientFrame struct {
+	Op    string `json:"op"`
+	JobID string `json:"job_id,omitempty"`
+}
+
+// authorizedFor reports whether c may subscribe to jobID's events: admin
+// connections may subscribe to anything (including allJobsWildcard for
+// monitoring), everyone else only to jobs they themselves submitted.
+func (h *WSHub) authorizedFor(c *client, jobID string) bool {
+	if c.isAdmin {
+		return true
+	}
+	if h.state == nil {
+		return false
+	}
+	state, err := h.state.Get(context.Background(), jobID)
+	if err != nil {
+		log.Printf("WS: failed to look up owner for job %s: %v", jobID, err)
+		return false
+	}
+	return state != nil && state.Owner == c.userID
+}
+
+// readPump enforces a read deadline extended by pong frames (so dead
+// clients get dropped) and parses subscribe/unsubscribe/ping control
+// frames the client sends to pick which job's events it wants fanned out
+// to it by fanOutJobEvent.
+func (h *WSHub) readPump(c *client) {
+	defer func() {
+		h.unregister <- c
+	}()
+
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var frame clientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			continue
+		}
+		switch frame.Op {
+		case "subscribe":
+			if frame.JobID == "" {
+				continue
+			}
+			if h.rateLimiter != nil {
+				allowed, err := h.rateLimiter.Allow(context.Background(), c.rateLimitKey())
+				if err != nil {
+					log.Printf("WS: rate limiter error: %v", err)
+					continue
+				}
+				if !allowed {
+					continue
+				}
+			}
+			if frame.JobID == allJobsWildcard {
+				if c.isAdmin {
+					c.subscribe(allJobsWildcard)
+				}
+				continue
+			}
+			if h.authorizedFor(c, frame.JobID) {
+				c.subscribe(frame.JobID)
+			}
+		case "unsubscribe":
+			if frame.JobID != "" {
+				c.unsubscribe(frame.JobID)
+			}
+		case "ping":
+			// No-op: the frame is read and dropped. The read deadline is
+			// extended only by pong frames (see SetPongHandler above).
+		}
+	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestArchiveOldDirsRoundTrip 打包一批快照目录，只留最近 1 个，校验被打包的
+// 目录消失、archive/ 下出现对应的 .tar.gz，并且 restoreArchive 能还原出原来
+// 的文件内容。
+func TestArchiveOldDirsRoundTrip(t *testing.T) {
+	base := t.TempDir()
+	now := time.Now()
+
+	var tags []string
+	for i := 0; i < 3; i++ {
+		tag := newSnapshotTag(now.Add(time.Duration(i) * time.Minute))
+		tags = append(tags, tag)
+		dir := filepath.Join(base, tag)
+		if err := os.MkdirAll(filepath.Join(dir, "nested"), 0o755); err != nil {
+			t.Fatalf("创建测试目录失败: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "nested", "summary.json"), []byte(`{"ok":true}`), 0o644); err != nil {
+			t.Fatalf("写测试文件失败: %v", err)
+		}
+		modTime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("设置 mtime 失败: %v", err)
+		}
+	}
+
+	if err := archiveOldDirs(base, 1, ArchiveOptions{}); err != nil {
+		t.Fatalf("archiveOldDirs 失败: %v", err)
+	}
+
+	// 最老的两个应该被打包并从 baseDir 里消失，只剩最近一个原样留着。
+	for _, tag := range tags[:2] {
+		if _, err := os.Stat(filepath.Join(base, tag)); !os.IsNotExist(err) {
+			t.Errorf("快照 %s 应该已经被打包删除，但还在: err=%v", tag, err)
+		}
+		archivePath := filepath.Join(base, defaultArchiveDirName, tag+".tar.gz")
+		if _, err := os.Stat(archivePath); err != nil {
+			t.Errorf("archive/%s.tar.gz 应该存在: %v", tag, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, tags[2])); err != nil {
+		t.Errorf("最近的快照 %s 不应该被打包: %v", tags[2], err)
+	}
+
+	snaps, err := listSnapshots(base)
+	if err != nil {
+		t.Fatalf("listSnapshots 失败: %v", err)
+	}
+	if len(snaps) != 3 {
+		t.Fatalf("len(snaps) = %d, want 3", len(snaps))
+	}
+	archivedCount := 0
+	for _, s := range snaps {
+		if s.Archived {
+			archivedCount++
+		}
+	}
+	if archivedCount != 2 {
+		t.Errorf("archivedCount = %d, want 2", archivedCount)
+	}
+
+	restoreDir := t.TempDir()
+	archivePath := filepath.Join(base, defaultArchiveDirName, tags[0]+".tar.gz")
+	if err := restoreArchive(archivePath, restoreDir); err != nil {
+		t.Fatalf("restoreArchive 失败: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(restoreDir, "nested", "summary.json"))
+	if err != nil {
+		t.Fatalf("还原出来的文件读取失败: %v", err)
+	}
+	if string(restored) != `{"ok":true}` {
+		t.Errorf("还原出来的内容不对: %q", restored)
+	}
+}
+
+// TestRestoreArchiveRejectsPathTraversal 构造一个条目名带 "../" 的恶意
+// tar.gz，确认 restoreArchive 拒绝写到 destDir 之外，而不是把文件甩到
+// 任意路径（CWE-22 tar-slip）。
+func TestRestoreArchiveRejectsPathTraversal(t *testing.T) {
+	base := t.TempDir()
+	archivePath := filepath.Join(base, "evil.tar.gz")
+	writeTestArchive(t, archivePath, []tar.Header{
+		{Name: "../../etc/cron.d/evil", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len("pwn"))},
+	}, []string{"pwn"})
+
+	restoreDir := filepath.Join(base, "restore")
+	if err := os.MkdirAll(restoreDir, 0o755); err != nil {
+		t.Fatalf("创建 restoreDir 失败: %v", err)
+	}
+	if err := restoreArchive(archivePath, restoreDir); err == nil {
+		t.Fatalf("restoreArchive 应该拒绝路径穿越条目，但没有返回错误")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(filepath.Dir(restoreDir)), "etc", "cron.d", "evil")); !os.IsNotExist(err) {
+		t.Errorf("路径穿越条目不应该被写到 restoreDir 之外")
+	}
+}
+
+// TestRestoreArchiveRejectsEscapingSymlink 构造一个符号链接条目指向
+// destDir 之外，确认 restoreArchive 拒绝创建它，防止后续条目借道该链接
+// 写出 destDir。
+func TestRestoreArchiveRejectsEscapingSymlink(t *testing.T) {
+	base := t.TempDir()
+	archivePath := filepath.Join(base, "evil-symlink.tar.gz")
+	writeTestArchive(t, archivePath, []tar.Header{
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "../../../../outside", Mode: 0o777},
+	}, []string{""})
+
+	restoreDir := filepath.Join(base, "restore")
+	if err := os.MkdirAll(restoreDir, 0o755); err != nil {
+		t.Fatalf("创建 restoreDir 失败: %v", err)
+	}
+	if err := restoreArchive(archivePath, restoreDir); err == nil {
+		t.Fatalf("restoreArchive 应该拒绝指向 destDir 之外的符号链接，但没有返回错误")
+	}
+}
+
+// writeTestArchive 写一个只含给定条目的 tar.gz，供路径穿越相关测试使用。
+func writeTestArchive(t *testing.T, path string, headers []tar.Header, bodies []string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("创建测试归档失败: %v", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for i, hdr := range headers {
+		h := hdr
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("写 tar header 失败: %v", err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(bodies[i])); err != nil {
+				t.Fatalf("写 tar body 失败: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("关闭 tar writer 失败: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("关闭 gzip writer 失败: %v", err)
+	}
+}
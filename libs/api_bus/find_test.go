@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGlobToRegex 覆盖典型的 *prefix / *suffix / 混合通配写法。
+func TestGlobToRegex(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"run-*", "run-20240102", true},
+		{"run-*", "sweep-20240102", false},
+		{"*-backtest", "run-20240102-backtest", true},
+		{"*-backtest", "run-20240102-sweep", false},
+		{"run-???", "run-001", true},
+		{"run-???", "run-0001", false},
+		{"*", "anything", true},
+	}
+	for _, c := range cases {
+		re, err := globToRegex(c.pattern)
+		if err != nil {
+			t.Fatalf("globToRegex(%q) 编译失败: %v", c.pattern, err)
+		}
+		if got := re.MatchString(c.name); got != c.want {
+			t.Errorf("globToRegex(%q).MatchString(%q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+// TestFindDirsPagination 在有多个匹配的目录时校验 Offset/Limit 分页，以及
+// 结果按 ModTime 从旧到新排序。
+func TestFindDirsPagination(t *testing.T) {
+	base := t.TempDir()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		dir := filepath.Join(base, "run-"+string(rune('a'+i)))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatalf("创建测试目录失败: %v", err)
+		}
+		modTime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("设置 mtime 失败: %v", err)
+		}
+	}
+
+	all, err := FindDirs(base, FindOptions{MatchDir: true})
+	if err != nil {
+		t.Fatalf("FindDirs 失败: %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("len(all) = %d, want 5", len(all))
+	}
+	for i := 0; i < len(all)-1; i++ {
+		if all[i].Info.ModTime().After(all[i+1].Info.ModTime()) {
+			t.Fatalf("结果没有按 ModTime 从旧到新排序: %v", all)
+		}
+	}
+
+	page, err := FindDirs(base, FindOptions{MatchDir: true, Offset: 2, Limit: 2})
+	if err != nil {
+		t.Fatalf("FindDirs 分页失败: %v", err)
+	}
+	if len(page) != 2 {
+		t.Fatalf("len(page) = %d, want 2", len(page))
+	}
+	if page[0].Path != all[2].Path || page[1].Path != all[3].Path {
+		t.Fatalf("分页结果不对: got %v, want [%s %s]", page, all[2].Path, all[3].Path)
+	}
+
+	tail, err := FindDirs(base, FindOptions{MatchDir: true, Offset: 4, Limit: 10})
+	if err != nil {
+		t.Fatalf("FindDirs 越界分页失败: %v", err)
+	}
+	if len(tail) != 1 || tail[0].Path != all[4].Path {
+		t.Fatalf("越界分页结果不对: got %v", tail)
+	}
+
+	empty, err := FindDirs(base, FindOptions{MatchDir: true, Offset: 100})
+	if err != nil {
+		t.Fatalf("FindDirs offset 超出长度失败: %v", err)
+	}
+	if len(empty) != 0 {
+		t.Fatalf("len(empty) = %d, want 0", len(empty))
+	}
+}
+
+// TestFindLatestDirDeepPicksByContentModTime 校验 findLatestDirDeep 按子
+// 目录内文件的最新 ModTime 挑选，而不是子目录自己的 ModTime：目录自身的
+// mtime 被特意设得比内容更旧。
+func TestFindLatestDirDeepPicksByContentModTime(t *testing.T) {
+	base := t.TempDir()
+	now := time.Now()
+
+	older := filepath.Join(base, "run-older")
+	newer := filepath.Join(base, "run-newer")
+	for _, dir := range []string{older, newer} {
+		if err := os.MkdirAll(filepath.Join(dir, "nested"), 0o755); err != nil {
+			t.Fatalf("创建测试目录失败: %v", err)
+		}
+	}
+
+	// run-newer 自己的目录 mtime 比 run-older 还旧，但它里面的文件是后写
+	// 的——findLatestDirDeep 应该依然选中 run-newer。
+	if err := os.WriteFile(filepath.Join(older, "summary.json"), []byte("old"), 0o644); err != nil {
+		t.Fatalf("写测试文件失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(newer, "nested", "summary.json"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("写测试文件失败: %v", err)
+	}
+	oldContentTime := now.Add(-time.Hour)
+	newContentTime := now
+	if err := os.Chtimes(filepath.Join(older, "summary.json"), oldContentTime, oldContentTime); err != nil {
+		t.Fatalf("设置 mtime 失败: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(newer, "nested", "summary.json"), newContentTime, newContentTime); err != nil {
+		t.Fatalf("设置 mtime 失败: %v", err)
+	}
+	dirModTime := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(older, dirModTime, dirModTime); err != nil {
+		t.Fatalf("设置目录 mtime 失败: %v", err)
+	}
+	if err := os.Chtimes(newer, dirModTime.Add(-time.Minute), dirModTime.Add(-time.Minute)); err != nil {
+		t.Fatalf("设置目录 mtime 失败: %v", err)
+	}
+
+	got, err := findLatestDirDeep(base, true, false)
+	if err != nil {
+		t.Fatalf("findLatestDirDeep 失败: %v", err)
+	}
+	if got != newer {
+		t.Fatalf("findLatestDirDeep() = %q, want %q", got, newer)
+	}
+
+	// 非递归模式下看不到 nested/ 里的文件，run-newer 退化成空目录（零值
+	// 时间），所以反而应该选中直接含文件的 run-older。
+	got, err = findLatestDirDeep(base, false, false)
+	if err != nil {
+		t.Fatalf("findLatestDirDeep(非递归) 失败: %v", err)
+	}
+	if got != older {
+		t.Fatalf("findLatestDirDeep(非递归) = %q, want %q", got, older)
+	}
+}
+
+// TestDeepestModTimeEmptyAndUnreadable 校验空目录按零值时间处理，并且遇到
+// 不存在/读不了的条目时跳过而不是中断整体扫描。
+func TestDeepestModTimeEmptyAndUnreadable(t *testing.T) {
+	base := t.TempDir()
+	empty := filepath.Join(base, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatalf("创建测试目录失败: %v", err)
+	}
+	if got := deepestModTime(empty, true, false); !got.IsZero() {
+		t.Fatalf("deepestModTime(空目录) = %v, want 零值", got)
+	}
+
+	missing := filepath.Join(base, "does-not-exist")
+	if got := deepestModTime(missing, true, false); !got.IsZero() {
+		t.Fatalf("deepestModTime(不存在的目录) = %v, want 零值", got)
+	}
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
+	"compress/gzip"
+	"container/heap"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,17 +28,73 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 	_ "modernc.org/sqlite"
 )
 
-// RunRequest 定义触发回测/扫参的请求体。
+// tracer 是整个 API Bus 的 OpenTelemetry tracer。没有配置 -otlp-endpoint 时，
+// otel 全局用的是默认的 no-op TracerProvider，Start 出来的 span 什么都不做，
+// 开销可以忽略；配置了之后 setupTracing 会把真正的 TracerProvider 装上去。
+var tracer = otel.Tracer("zenithalgo/api_bus")
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zenith_api_bus_queue_depth",
+		Help: "Number of tasks currently queued (pending dispatch), labeled by task type.",
+	}, []string{"task_type"})
+	workerBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "zenith_api_bus_workers_busy",
+		Help: "Number of worker goroutines currently executing a task.",
+	})
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zenith_api_bus_task_duration_seconds",
+		Help:    "Task execution time from start to finish, labeled by type and success.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"task_type", "success"})
+	taskRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "zenith_api_bus_task_retries_total",
+		Help: "Number of times a task was requeued for retry after failing.",
+	})
+	storageLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zenith_api_bus_storage_latency_seconds",
+		Help:    "SQLite Storage method latency, labeled by method name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	wsHubClients = prometheus.NewDesc(
+		"zenith_api_bus_ws_clients",
+		"Number of WebSocket clients currently connected to the API Bus hub.",
+		nil, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, workerBusy, taskDuration, taskRetries, storageLatency)
+}
+
+// RunRequest 定义触发回测/扫参的请求体。Priority 数字越大越先跑；Symbol 用于
+// 按市场做公平调度和并发限流；Labels 是任意打标签用的键值对，随任务一起存下来。
+// IdempotencyKey 可以来自请求体，也可以来自 Idempotency-Key 请求头（parseRequest
+// 负责回填），同一个 key 配上同一份配置在 TTL 内重复提交会直接拿到已有任务。
 type RunRequest struct {
-	Config string `json:"config"`
-	TopN   int    `json:"top_n,omitempty"`
+	Config         string            `json:"config"`
+	TopN           int               `json:"top_n,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	Symbol         string            `json:"symbol,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
 }
 
-// RunResponse 定义统一返回结构。
+// RunResponse 定义统一返回结构。Stdout/Stderr 只保留最近 runOutputTailLines
+// 行，完整输出落在 LogStore 写的 NDJSON 文件里，通过 /api/v1/tasks/{id}/logs 读取。
 type RunResponse struct {
 	OK         bool   `json:"ok"`
 	ExitCode   int    `json:"exit_code"`
@@ -60,27 +124,46 @@ type Task struct {
 	LastError  string       `json:"last_error,omitempty"`
 	Attempts   int          `json:"attempts"`
 	MaxRetries int          `json:"max_retries"`
+	ConfigHash string       `json:"config_hash,omitempty"`
 	CreatedAt  time.Time    `json:"created_at"`
 	StartedAt  *time.Time   `json:"started_at,omitempty"`
 	FinishedAt *time.Time   `json:"finished_at,omitempty"`
 }
 
-// TaskResponse 任务提交时的返回结构。
+// TaskResponse 任务提交时的返回结构。Deduped 为 true 表示命中了幂等键或
+// dedupe=true 的 config_hash 查重，TaskID 指向的是已有任务而不是新建的。
 type TaskResponse struct {
-	OK     bool       `json:"ok"`
-	TaskID string     `json:"task_id"`
-	Status TaskStatus `json:"status"`
-	Error  string     `json:"error,omitempty"`
+	OK      bool       `json:"ok"`
+	TaskID  string     `json:"task_id"`
+	Status  TaskStatus `json:"status"`
+	Deduped bool       `json:"deduped,omitempty"`
+	Error   string     `json:"error,omitempty"`
 }
 
 type ServerConfig struct {
 	Addr         string
 	RepoRoot     string
 	PythonBin    string
+	PyWorkers    int
 	Timeout      time.Duration
 	DBPath       string
 	MaxRetries   int
 	RetryBackoff time.Duration
+
+	// RedisAddr 留空时任务队列退回到单机内存实现；配置后使用 Redis Streams，
+	// 让多个 API Bus 实例共享同一条队列并能互相接管对方没处理完的任务。
+	RedisAddr         string
+	RedisStream       string
+	RedisGroup        string
+	VisibilityTimeout time.Duration
+
+	// MaxConcurrentPerSymbol 限制同一 symbol 同时在跑的任务数（0 表示不限），
+	// 避免一个品种的扫参把所有 worker 都占满，饿死其他品种。
+	MaxConcurrentPerSymbol int
+
+	// IdempotencyTTL 幂等键/config_hash 查重的有效期，超过这个时间的旧任务
+	// 不再被当作重复提交拦下来。
+	IdempotencyTTL time.Duration
 }
 
 func main() {
@@ -92,15 +175,31 @@ func main() {
 	var dbPath string
 	var maxRetries int
 	var retryBackoffMs int
+	var redisAddr string
+	var redisStream string
+	var redisGroup string
+	var visibilityTimeoutMs int
+	var maxConcurrentPerSymbol int
+	var idempotencyTTLSec int
+	var otlpEndpoint string
+	var pyWorkers int
 
 	flag.StringVar(&addr, "addr", ":8000", "监听地址，例如 :8000")
 	flag.StringVar(&repoRoot, "repo", "", "仓库根目录，留空则使用当前工作目录")
 	flag.StringVar(&pythonBin, "python", "", "Python 解释器路径（默认优先使用 .venv/bin/python）")
 	flag.IntVar(&timeoutSec, "timeout", 0, "单次任务超时秒数（0 表示不限时）")
+	flag.IntVar(&pyWorkers, "py-workers", 4, "长驻 Python worker 进程数，取代每个任务单独 fork 一次解释器")
 	flag.IntVar(&workers, "workers", 1, "并发 worker 数")
 	flag.StringVar(&dbPath, "db", "", "SQLite 路径（留空则放在 results/api_bus.sqlite3）")
 	flag.IntVar(&maxRetries, "max-retries", 0, "失败重试次数（0 表示不重试）")
 	flag.IntVar(&retryBackoffMs, "retry-backoff-ms", 1000, "重试延迟（毫秒）")
+	flag.StringVar(&redisAddr, "redis-addr", "", "Redis 地址（留空则使用内存队列，单实例场景）")
+	flag.StringVar(&redisStream, "redis-stream", "api_bus:tasks", "Redis Streams 队列的 stream key")
+	flag.StringVar(&redisGroup, "redis-group", "api_bus", "Redis Streams 消费组名称")
+	flag.IntVar(&visibilityTimeoutMs, "visibility-timeout-ms", 30000, "任务被认领后，多久算作 worker 可能已崩溃并可被其他实例重新认领（毫秒）")
+	flag.IntVar(&maxConcurrentPerSymbol, "max-concurrent-per-symbol", 0, "同一 symbol 同时运行的任务数上限（0 表示不限）")
+	flag.IntVar(&idempotencyTTLSec, "idempotency-ttl-sec", 86400, "幂等键/config_hash 查重的有效期（秒）")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP gRPC collector 地址（如 localhost:4317），留空则不开启 tracing（no-op）")
 	flag.Parse()
 
 	if repoRoot == "" {
@@ -122,14 +221,32 @@ func main() {
 	}
 
 	cfg := ServerConfig{
-		Addr:         addr,
-		RepoRoot:     repoRoot,
-		PythonBin:    pythonBin,
-		Timeout:      time.Duration(timeoutSec) * time.Second,
-		DBPath:       dbPath,
-		MaxRetries:   maxRetries,
-		RetryBackoff: time.Duration(retryBackoffMs) * time.Millisecond,
+		Addr:              addr,
+		RepoRoot:          repoRoot,
+		PythonBin:         pythonBin,
+		PyWorkers:         pyWorkers,
+		Timeout:           time.Duration(timeoutSec) * time.Second,
+		DBPath:            dbPath,
+		MaxRetries:        maxRetries,
+		RetryBackoff:      time.Duration(retryBackoffMs) * time.Millisecond,
+		RedisAddr:         redisAddr,
+		RedisStream:       redisStream,
+		RedisGroup:        redisGroup,
+		VisibilityTimeout: time.Duration(visibilityTimeoutMs) * time.Millisecond,
+
+		MaxConcurrentPerSymbol: maxConcurrentPerSymbol,
+		IdempotencyTTL:         time.Duration(idempotencyTTLSec) * time.Second,
+	}
+
+	shutdownTracing, err := setupTracing(otlpEndpoint)
+	if err != nil {
+		log.Fatalf("初始化 OpenTelemetry tracing 失败: %v", err)
 	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("关闭 tracing 失败: %v", err)
+		}
+	}()
 
 	if cfg.DBPath == "" {
 		cfg.DBPath = filepath.Join(cfg.RepoRoot, "results", "api_bus.sqlite3")
@@ -138,9 +255,31 @@ func main() {
 	if err != nil {
 		log.Fatalf("初始化 SQLite 失败: %v", err)
 	}
-	queue := newTaskQueue(cfg, workers, store)
+
+	var backend Backend
+	if cfg.RedisAddr != "" {
+		rb, err := newRedisBackend(cfg.RedisAddr, cfg.RedisStream, cfg.RedisGroup, cfg.VisibilityTimeout)
+		if err != nil {
+			log.Fatalf("初始化 Redis 任务队列失败: %v", err)
+		}
+		backend = rb
+		log.Printf("任务队列后端: redis(%s) stream=%s group=%s", cfg.RedisAddr, cfg.RedisStream, cfg.RedisGroup)
+	} else {
+		backend = newMemoryBackend(128)
+		log.Println("任务队列后端: 内存（未配置 -redis-addr，单实例场景）")
+	}
+
+	pyPool, err := newPythonPool(cfg, cfg.PyWorkers)
+	if err != nil {
+		log.Fatalf("启动 Python worker 池失败: %v", err)
+	}
+	defer pyPool.Close()
+
+	logs := newLogStore(filepath.Join(cfg.RepoRoot, "results", "logs"))
+	queue := newTaskQueue(cfg, workers, store, backend, logs, pyPool)
 	hub := newHub()
 	queue.hub = hub
+	prometheus.MustRegister(hub)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", handleHealth)
@@ -149,13 +288,38 @@ func main() {
 	mux.HandleFunc("/api/v1/tasks/", handleTaskGet(queue))
 	mux.HandleFunc("/api/v1/runs", handleRuns(queue))
 	mux.HandleFunc("/ws", handleWS(hub))
+	mux.Handle("/metrics", promhttp.Handler())
 
-	log.Printf("API Bus 启动: addr=%s repo=%s python=%s", cfg.Addr, cfg.RepoRoot, cfg.PythonBin)
+	log.Printf("API Bus 启动: addr=%s repo=%s python=%s py-workers=%d", cfg.Addr, cfg.RepoRoot, cfg.PythonBin, cfg.PyWorkers)
 	if err := http.ListenAndServe(cfg.Addr, mux); err != nil {
 		log.Fatalf("HTTP 服务启动失败: %v", err)
 	}
 }
 
+// setupTracing 在 otlpEndpoint 非空时接一个 OTLP gRPC exporter 并把它装成全局
+// TracerProvider；留空则什么都不做，otel 包内置的默认 TracerProvider 本身就是
+// no-op 实现，tracer.Start 调用的开销可以忽略不计。返回的 shutdown 函数负责
+// 在进程退出前把还没发出去的 span 刷盘。
+func setupTracing(otlpEndpoint string) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if otlpEndpoint == "" {
+		return noop, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithEndpoint(otlpEndpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return noop, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	log.Printf("tracing 已启用: otlp-endpoint=%s", otlpEndpoint)
+	return tp.Shutdown, nil
+}
+
 func handleHealth(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "message": "ok"})
 }
@@ -174,8 +338,9 @@ func handleBacktest(queue *TaskQueue) http.HandlerFunc {
 		if strings.TrimSpace(req.Config) == "" {
 			req.Config = "config/config.yml"
 		}
-		task := queue.Enqueue("backtest", req)
-		writeJSON(w, http.StatusOK, TaskResponse{OK: true, TaskID: task.ID, Status: task.Status})
+		dedupe := r.URL.Query().Get("dedupe") == "true"
+		task, deduped := queue.Enqueue(r.Context(), "backtest", req, dedupe)
+		writeJSON(w, http.StatusOK, TaskResponse{OK: true, TaskID: task.ID, Status: task.Status, Deduped: deduped})
 	}
 }
 
@@ -193,23 +358,37 @@ func handleSweep(queue *TaskQueue) http.HandlerFunc {
 		if strings.TrimSpace(req.Config) == "" {
 			req.Config = "config/config.yml"
 		}
-		task := queue.Enqueue("sweep", req)
-		writeJSON(w, http.StatusOK, TaskResponse{OK: true, TaskID: task.ID, Status: task.Status})
+		dedupe := r.URL.Query().Get("dedupe") == "true"
+		task, deduped := queue.Enqueue(r.Context(), "sweep", req, dedupe)
+		writeJSON(w, http.StatusOK, TaskResponse{OK: true, TaskID: task.ID, Status: task.Status, Deduped: deduped})
 	}
 }
 
 func handleTaskGet(queue *TaskQueue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "仅支持 GET"})
+		rest := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
+		if strings.TrimSpace(rest) == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error": "缺少 task_id"})
 			return
 		}
-		id := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
-		if strings.TrimSpace(id) == "" {
-			writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error": "缺少 task_id"})
+		if id, ok := strings.CutSuffix(rest, "/logs"); ok {
+			handleTaskLogs(queue, w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(rest, "/cancel"); ok {
+			handleTaskCancel(queue, w, r, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(rest, "/priority"); ok {
+			handleTaskPriority(queue, w, r, id)
 			return
 		}
-		task, ok := queue.Get(id)
+
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "仅支持 GET"})
+			return
+		}
+		task, ok := queue.Get(rest)
 		if !ok {
 			writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "error": "task_id 不存在"})
 			return
@@ -218,6 +397,106 @@ func handleTaskGet(queue *TaskQueue) http.HandlerFunc {
 	}
 }
 
+// handleTaskCancel 实现 POST /api/v1/tasks/{id}/cancel：取消一个正在执行的
+// Python 子进程。任务还在排队（没有 CancelFunc）或已经结束时返回 404。
+func handleTaskCancel(queue *TaskQueue, w http.ResponseWriter, r *http.Request, taskID string) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "仅支持 POST"})
+		return
+	}
+	if !queue.Cancel(taskID) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "error": "任务未在运行，无法取消"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleTaskPriority 实现 POST /api/v1/tasks/{id}/priority：调整一个还在
+// 排队、尚未被 worker 取走的任务的优先级，请求体为 {"priority": N}。
+func handleTaskPriority(queue *TaskQueue, w http.ResponseWriter, r *http.Request, taskID string) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "仅支持 POST"})
+		return
+	}
+	var body struct {
+		Priority int `json:"priority"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error": err.Error()})
+		return
+	}
+	if !queue.Reprioritize(r.Context(), taskID, body.Priority) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "error": "任务不在排队中，无法调整优先级"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleTaskLogs 实现 GET /api/v1/tasks/{id}/logs?follow=1&from_seq=N：
+// from_seq 只返回序号更大的日志行，follow=1 时持续轮询磁盘文件，直到任务
+// 结束且没有更多新行，或者客户端断开连接。
+func handleTaskLogs(queue *TaskQueue, w http.ResponseWriter, r *http.Request, taskID string) {
+	if queue.logs == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"ok": false, "error": "未启用日志存储"})
+		return
+	}
+
+	var fromSeq uint64
+	if v := r.URL.Query().Get("from_seq"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			fromSeq = n
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+
+	records, err := queue.logs.ReadFrom(taskID, fromSeq)
+	if err != nil && !os.IsNotExist(err) {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"ok": false, "error": err.Error()})
+		return
+	}
+	if !follow {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "logs": records})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	writeRecords := func(recs []logRecord) {
+		for _, rec := range recs {
+			_ = enc.Encode(rec)
+			fromSeq = rec.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	writeRecords(records)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			more, err := queue.logs.ReadFrom(taskID, fromSeq)
+			if err != nil {
+				return
+			}
+			writeRecords(more)
+			if task, ok := queue.Get(taskID); ok && len(more) == 0 &&
+				(task.Status == TaskSucceeded || task.Status == TaskFailed) {
+				return
+			}
+		}
+	}
+}
+
 func handleRuns(queue *TaskQueue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -235,13 +514,14 @@ func handleRuns(queue *TaskQueue) http.HandlerFunc {
 			}
 		}
 		filter := RunFilter{
-			Limit:  limit,
-			TaskID: strings.TrimSpace(r.URL.Query().Get("task_id")),
-			Symbol: strings.TrimSpace(r.URL.Query().Get("symbol")),
-			From:   strings.TrimSpace(r.URL.Query().Get("from")),
-			To:     strings.TrimSpace(r.URL.Query().Get("to")),
+			Limit:          limit,
+			TaskID:         strings.TrimSpace(r.URL.Query().Get("task_id")),
+			Symbol:         strings.TrimSpace(r.URL.Query().Get("symbol")),
+			From:           strings.TrimSpace(r.URL.Query().Get("from")),
+			To:             strings.TrimSpace(r.URL.Query().Get("to")),
+			IdempotencyKey: strings.TrimSpace(r.URL.Query().Get("idempotency_key")),
 		}
-		runs, err := queue.store.ListRuns(filter)
+		runs, err := queue.store.ListRuns(r.Context(), filter)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"ok": false, "error": err.Error()})
 			return
@@ -255,94 +535,276 @@ func parseRequest(r *http.Request) (RunRequest, error) {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
-		if errors.Is(err, io.EOF) {
-			return req, nil
+		if !errors.Is(err, io.EOF) {
+			return RunRequest{}, err
 		}
-		return RunRequest{}, err
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = strings.TrimSpace(r.Header.Get("Idempotency-Key"))
 	}
 	return req, nil
 }
 
-type logCallback func(stream string, line string)
+// logCallback 的 traceID 参数携带着 runMain 这次执行的 span trace id，
+// 落盘时随每一行 NDJSON 日志一起写下去（见 logRecord.TraceID），这样看日志
+// 的人可以直接跳到 Tempo/Grafana 里对应的那次 trace。
+type logCallback func(stream string, line string, traceID string)
+
+// runMain 把一次 backtest/sweep 执行委派给 Python worker 池，套一层 span 和
+// cfg.Timeout 的截止时间。真正的进程通信、重试/respawn 都在 PythonPool 里。
+func runMain(ctx context.Context, cfg ServerConfig, pool *PythonPool, method string, params map[string]any, logFn logCallback) (result RunResponse) {
+	ctx, span := tracer.Start(ctx, "runMain", trace.WithAttributes(
+		attribute.String("run.method", method),
+	))
+	traceID := span.SpanContext().TraceID().String()
+	defer func() {
+		span.SetAttributes(attribute.Bool("run.ok", result.OK), attribute.Int("run.exit_code", result.ExitCode))
+		if !result.OK {
+			span.SetStatus(codes.Error, result.Error)
+		}
+		span.End()
+	}()
 
-func runMain(cfg ServerConfig, logFn logCallback, args ...string) RunResponse {
-	start := time.Now()
-	ctx := context.Background()
 	if cfg.Timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
 		defer cancel()
 	}
-	pyArgs := append([]string{"main.py"}, args...)
-	cmd := exec.CommandContext(ctx, cfg.PythonBin, pyArgs...)
-	cmd.Dir = cfg.RepoRoot
 
-	stdoutPipe, _ := cmd.StdoutPipe()
-	stderrPipe, _ := cmd.StderrPipe()
+	return pool.Run(ctx, method, params, traceID, logFn)
+}
+
+// runOutputTailLines 是 RunResponse.Stdout/Stderr 保留的最近行数；完整历史
+// 由 logFn 落进 LogStore 管理的 NDJSON 文件，不再无限堆在内存里。
+const runOutputTailLines = 200
+
+// boundedLines 只保留最近 max 行文本。
+type boundedLines struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func newBoundedLines(max int) *boundedLines {
+	return &boundedLines{max: max}
+}
+
+func (b *boundedLines) add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines = append(b.lines, line)
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+}
+
+func (b *boundedLines) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Join(b.lines, "\n")
+}
+
+// rpcFrame 是 API Bus 和 rpc_worker.py 之间的 JSON-RPC 协议帧，一行一个 JSON
+// 对象，经 worker 进程的 stdin/stdout 传输：
+//   - 请求帧（Go -> worker）: {id, method: "backtest"|"sweep"|"cancel", params}
+//   - 流式输出帧（worker -> Go）: {id, stream: "stdout"|"stderr", line}
+//   - 终态帧（worker -> Go）: {id, ok, exit_code, duration_ms, error}
+//   - 握手帧（worker -> Go，启动时发一次）: {ready: true}
+type rpcFrame struct {
+	ID         uint64         `json:"id"`
+	Method     string         `json:"method,omitempty"`
+	Params     map[string]any `json:"params,omitempty"`
+	Stream     string         `json:"stream,omitempty"`
+	Line       string         `json:"line,omitempty"`
+	OK         *bool          `json:"ok,omitempty"`
+	ExitCode   int            `json:"exit_code,omitempty"`
+	DurationMs int64          `json:"duration_ms,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Ready      bool           `json:"ready,omitempty"`
+}
+
+// cancelGrace 是发出 cancel 帧之后，等 worker 自己优雅收尾的时间；超过这个
+// 时间还没见到终态帧，就认定 worker 卡死了，直接 kill 掉换一个新的。
+const cancelGrace = 5 * time.Second
+
+// pythonWorker 是一个长驻的 `python3 rpc_worker.py` 子进程。一个 worker 同一
+// 时刻只处理一个请求，多 worker 之间的多路复用由 PythonPool 负责。
+type pythonWorker struct {
+	id     int
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	out    *bufio.Scanner
+	nextID uint64
+}
+
+// startPythonWorker 拉起一个 worker 进程并等待它的启动握手帧。
+func startPythonWorker(cfg ServerConfig, id int) (*pythonWorker, error) {
+	cmd := exec.Command(cfg.PythonBin, "rpc_worker.py")
+	cmd.Dir = cfg.RepoRoot
+	cmd.Stderr = os.Stderr
 
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
 	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	out := bufio.NewScanner(stdout)
+	out.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	if !out.Scan() {
+		_ = cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, fmt.Errorf("worker %d 未收到启动握手帧", id)
+	}
+	var hs rpcFrame
+	if err := json.Unmarshal(out.Bytes(), &hs); err != nil || !hs.Ready {
+		_ = cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, fmt.Errorf("worker %d 握手帧无效: %s", id, out.Text())
+	}
+
+	return &pythonWorker{id: id, cmd: cmd, stdin: stdin, out: out}, nil
+}
+
+// run 发一个请求帧，阻塞读取流式输出帧直到终态帧为止。ctx 被取消或超时后先
+// 发 cancel 帧给 worker 一次优雅退出的机会，cancelGrace 过后还没见到终态帧
+// 就直接 kill 掉——crashed=true 告诉调用方这个 worker 已经报废，需要换新的。
+func (w *pythonWorker) run(ctx context.Context, method string, params map[string]any, traceID string, logFn logCallback) (result RunResponse, crashed bool) {
+	start := time.Now()
+	id := atomic.AddUint64(&w.nextID, 1)
+
+	req, err := json.Marshal(rpcFrame{ID: id, Method: method, Params: params})
+	if err != nil {
+		return RunResponse{OK: false, ExitCode: -1, Error: err.Error(), DurationMs: time.Since(start).Milliseconds()}, false
+	}
+	if _, err := w.stdin.Write(append(req, '\n')); err != nil {
+		return RunResponse{OK: false, ExitCode: -1, Error: err.Error(), DurationMs: time.Since(start).Milliseconds()}, true
+	}
+
+	stdoutTail := newBoundedLines(runOutputTailLines)
+	stderrTail := newBoundedLines(runOutputTailLines)
+
+	frames := make(chan rpcFrame)
+	go func() {
+		defer close(frames)
+		for w.out.Scan() {
+			var f rpcFrame
+			if json.Unmarshal(w.out.Bytes(), &f) != nil || f.ID != id {
+				continue
+			}
+			frames <- f
+			if f.OK != nil {
+				return
+			}
+		}
+	}()
+
+	crashedResp := func(errMsg string) RunResponse {
 		return RunResponse{
-			OK:         false,
-			ExitCode:   -1,
-			Stdout:     "",
-			Stderr:     "",
+			OK: false, ExitCode: -1,
+			Stdout: stdoutTail.String(), Stderr: stderrTail.String(),
 			DurationMs: time.Since(start).Milliseconds(),
-			Error:      err.Error(),
+			Error:      errMsg,
 		}
 	}
 
-	var stdoutBuf strings.Builder
-	var stderrBuf strings.Builder
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		streamLogs(stdoutPipe, &stdoutBuf, "stdout", logFn)
-	}()
-	go func() {
-		defer wg.Done()
-		streamLogs(stderrPipe, &stderrBuf, "stderr", logFn)
-	}()
+	ctxDone := ctx.Done()
+	var grace <-chan time.Time
+	for {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				// worker 自己挂了（OOM、未捕获异常等），stdout 被读到 EOF，
+				// 不是我们主动 Kill 的——同样要 Wait 掉，否则这是比超时更
+				// 常见的崩溃路径，每次都会漏一个僵尸进程。
+				go w.cmd.Wait()
+				return crashedResp("python worker 意外退出"), true
+			}
+			if f.OK != nil {
+				return RunResponse{
+					OK: *f.OK, ExitCode: f.ExitCode,
+					Stdout: stdoutTail.String(), Stderr: stderrTail.String(),
+					DurationMs: time.Since(start).Milliseconds(),
+					Error:      f.Error,
+				}, false
+			}
+			switch f.Stream {
+			case "stdout":
+				stdoutTail.add(f.Line)
+			case "stderr":
+				stderrTail.add(f.Line)
+			}
+			if logFn != nil {
+				logFn(f.Stream, f.Line, traceID)
+			}
+		case <-ctxDone:
+			ctxDone = nil
+			cancelReq, _ := json.Marshal(rpcFrame{ID: id, Method: "cancel"})
+			_, _ = w.stdin.Write(append(cancelReq, '\n'))
+			grace = time.After(cancelGrace)
+		case <-grace:
+			_ = w.cmd.Process.Kill()
+			go w.cmd.Wait()
+			return crashedResp(ctx.Err().Error()), true
+		}
+	}
+}
 
-	err := cmd.Wait()
-	wg.Wait()
-	duration := time.Since(start).Milliseconds()
+// PythonPool 维护 N 个长驻 Python worker 进程，取代每个任务都 fork 一次
+// `python3 main.py` 的老方案——长驻进程省掉了解释器 + pandas/numpy 的启动
+// 开销（原来每次 1-3 秒），让高频扫参的 fan-out 变得可行。
+type PythonPool struct {
+	cfg     ServerConfig
+	workers chan *pythonWorker
+}
 
-	resp := RunResponse{
-		OK:         err == nil,
-		ExitCode:   exitCode(err),
-		Stdout:     stdoutBuf.String(),
-		Stderr:     stderrBuf.String(),
-		DurationMs: duration,
+func newPythonPool(cfg ServerConfig, n int) (*PythonPool, error) {
+	if n <= 0 {
+		n = 1
 	}
-	if err != nil {
-		resp.Error = err.Error()
+	pool := &PythonPool{cfg: cfg, workers: make(chan *pythonWorker, n)}
+	for i := 0; i < n; i++ {
+		w, err := startPythonWorker(cfg, i+1)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("启动 python worker %d 失败: %w", i+1, err)
+		}
+		pool.workers <- w
 	}
-	return resp
+	return pool, nil
 }
 
-func streamLogs(r io.Reader, buf *strings.Builder, stream string, logFn logCallback) {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-	for scanner.Scan() {
-		line := scanner.Text()
-		buf.WriteString(line)
-		buf.WriteString("\n")
-		if logFn != nil {
-			logFn(stream, line)
+// Run 从池里借一个空闲 worker 跑一次 method(params)，执行期间通过 logFn 转发
+// 流式输出。worker 崩溃时当场换一个新进程再放回池子，调用方不需要关心。
+func (p *PythonPool) Run(ctx context.Context, method string, params map[string]any, traceID string, logFn logCallback) RunResponse {
+	w := <-p.workers
+	result, crashed := w.run(ctx, method, params, traceID, logFn)
+	if crashed {
+		log.Printf("python worker %d 已崩溃或无响应，正在重启", w.id)
+		if fresh, err := startPythonWorker(p.cfg, w.id); err != nil {
+			log.Printf("重启 python worker %d 失败，稍后下次借用时会重试: %v", w.id, err)
+		} else {
+			w = fresh
 		}
 	}
+	p.workers <- w
+	return result
 }
 
-func exitCode(err error) int {
-	if err == nil {
-		return 0
+// Close 杀掉池子里所有 worker 进程，供进程退出时清理。
+func (p *PythonPool) Close() {
+	close(p.workers)
+	for w := range p.workers {
+		_ = w.stdin.Close()
+		_ = w.cmd.Process.Kill()
+		go w.cmd.Wait()
 	}
-	var exitErr *exec.ExitError
-	if errors.As(err, &exitErr) {
-		return exitErr.ExitCode()
-	}
-	return -1
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload any) {
@@ -353,653 +815,2334 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	_ = enc.Encode(payload)
 }
 
-// TaskQueue 负责任务排队与状态管理（内存版）。
-type TaskQueue struct {
-	cfg     ServerConfig
-	queue   chan *Task
-	mu      sync.RWMutex
-	tasks   map[string]*Task
-	seq     uint64
-	workers int
-	store   *Storage
-	hub     *Hub
+// QueuedTask 是 Backend.Reserve 返回的最小信息：要处理哪个任务，以及
+// Ack/Nack 这次具体投递时要带上的 token（Redis Streams 下是消息 ID）。
+type QueuedTask struct {
+	TaskID string
+	Token  string
 }
 
-func newTaskQueue(cfg ServerConfig, workers int, store *Storage) *TaskQueue {
-	if workers <= 0 {
-		workers = 1
-	}
-	tq := &TaskQueue{
-		cfg:     cfg,
-		queue:   make(chan *Task, 128),
-		tasks:   make(map[string]*Task),
-		workers: workers,
-		store:   store,
-	}
-	if store != nil {
-		loaded, err := store.LoadTasks()
-		if err != nil {
-			log.Printf("加载历史任务失败: %v", err)
-		} else {
-			for _, task := range loaded {
-				tq.tasks[task.ID] = task
-				// 服务重启后，把未完成任务重新入队
-				if task.Status == TaskPending || task.Status == TaskRunning {
-					task.Status = TaskPending
-					task.StartedAt = nil
-					task.FinishedAt = nil
-					tq.queue <- task
-				}
-			}
-		}
-	}
-	for i := 0; i < workers; i++ {
-		go tq.worker(i + 1)
-	}
-	return tq
+// Backend 抽象任务队列的投递层。TaskQueue 本身只管任务的状态机和执行，
+// 任务怎么排队、怎么在多个 API Bus 实例间分发、worker 崩溃后怎么找回，
+// 交给具体的 Backend 实现决定。
+type Backend interface {
+	// Enqueue 把任务 ID 放入队列，等待某个 worker Reserve 到它。
+	Enqueue(ctx context.Context, taskID string) error
+	// Reserve 阻塞直到有任务可处理。ctx 被取消时返回 ctx.Err()。
+	Reserve(ctx context.Context) (QueuedTask, error)
+	// Ack 确认这次投递已经处理完毕（不管成功还是最终失败）。
+	Ack(ctx context.Context, qt QueuedTask) error
+	// Nack 放弃这次投递但不确认完成，留给可见性超时机制重新认领。
+	Nack(ctx context.Context, qt QueuedTask) error
+	// Requeue 显式地把任务重新放回队列（用于业务层面的失败重试）。
+	Requeue(ctx context.Context, taskID string) error
+	// LoadPending 返回队列里还没处理完的任务 ID，供启动时和 SQLite 的任务索引对账。
+	LoadPending(ctx context.Context) ([]string, error)
 }
 
-// Enqueue 创建任务并入队。
-func (tq *TaskQueue) Enqueue(taskType string, req RunRequest) *Task {
-	id := tq.nextID()
-	task := &Task{
-		ID:         id,
-		Type:       taskType,
-		Request:    req,
-		Status:     TaskPending,
-		MaxRetries: tq.cfg.MaxRetries,
-		CreatedAt:  time.Now(),
+// MemoryBackend 是单进程内存队列，对应重构前 TaskQueue 里裸的 chan *Task：
+// 没有持久化也没有跨实例协调，服务重启后全部任务都靠 SQLite 重新入队。
+type MemoryBackend struct {
+	ch chan string
+}
+
+func newMemoryBackend(size int) *MemoryBackend {
+	return &MemoryBackend{ch: make(chan string, size)}
+}
+
+func (b *MemoryBackend) Enqueue(_ context.Context, taskID string) error {
+	b.ch <- taskID
+	return nil
+}
+
+func (b *MemoryBackend) Reserve(ctx context.Context) (QueuedTask, error) {
+	select {
+	case id := <-b.ch:
+		return QueuedTask{TaskID: id, Token: id}, nil
+	case <-ctx.Done():
+		return QueuedTask{}, ctx.Err()
 	}
-	tq.mu.Lock()
-	tq.tasks[id] = task
-	tq.mu.Unlock()
+}
 
-	if tq.store != nil {
-		if err := tq.store.SaveTask(task); err != nil {
-			log.Printf("保存任务失败: %v", err)
+func (b *MemoryBackend) Ack(_ context.Context, _ QueuedTask) error  { return nil }
+func (b *MemoryBackend) Nack(_ context.Context, _ QueuedTask) error { return nil }
+
+func (b *MemoryBackend) Requeue(ctx context.Context, taskID string) error {
+	return b.Enqueue(ctx, taskID)
+}
+
+func (b *MemoryBackend) LoadPending(_ context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// RedisBackend 用 Redis Streams 的消费组实现跨实例共享队列：XADD 入队，
+// XREADGROUP（BLOCK）出队，XACK 确认，XAUTOCLAIM 在可见性超时后把
+// 崩溃 worker 手里的任务交给别的实例。SQLite 仍然是任务/结果的权威索引，
+// Redis 只负责队列本身的活跃状态。
+type RedisBackend struct {
+	client     *redis.Client
+	stream     string
+	group      string
+	consumer   string
+	visibility time.Duration
+}
+
+func newRedisBackend(addr, stream, group string, visibility time.Duration) (*RedisBackend, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+	if err := client.XGroupCreateMkStream(ctx, stream, group, "0").Err(); err != nil {
+		if !strings.Contains(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("创建消费组失败: %w", err)
 		}
 	}
-	tq.broadcastTask(task)
 
-	tq.queue <- task
-	return task
+	hostname, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+
+	return &RedisBackend{
+		client:     client,
+		stream:     stream,
+		group:      group,
+		consumer:   consumer,
+		visibility: visibility,
+	}, nil
 }
 
-// Get 查询任务状态。
-func (tq *TaskQueue) Get(id string) (*Task, bool) {
-	tq.mu.RLock()
-	defer tq.mu.RUnlock()
-	task, ok := tq.tasks[id]
-	return task, ok
+func (b *RedisBackend) Enqueue(ctx context.Context, taskID string) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		Values: map[string]any{"task_id": taskID},
+	}).Err()
 }
 
-func (tq *TaskQueue) nextID() string {
-	seq := atomic.AddUint64(&tq.seq, 1)
-	return time.Now().Format("20060102150405") + "-" + strconv.FormatUint(seq, 10)
+func (b *RedisBackend) Requeue(ctx context.Context, taskID string) error {
+	return b.Enqueue(ctx, taskID)
 }
 
-func (tq *TaskQueue) worker(_ int) {
-	for task := range tq.queue {
-		tq.update(task.ID, func(t *Task) {
-			t.Status = TaskRunning
-			t.Attempts += 1
-			now := time.Now()
-			t.StartedAt = &now
-		})
-		tq.broadcastTask(task)
-		if tq.store != nil {
-			if err := tq.store.UpdateTask(task.ID, task); err != nil {
-				log.Printf("更新任务失败: %v", err)
-			}
+func (b *RedisBackend) Reserve(ctx context.Context) (QueuedTask, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return QueuedTask{}, err
 		}
 
-		var args []string
-		switch task.Type {
-		case "backtest":
-			args = []string{"backtest", "--config", task.Request.Config}
-		case "sweep":
-			args = []string{"sweep", "--config", task.Request.Config}
-			if task.Request.TopN > 0 {
-				args = append(args, "--top-n", strconv.Itoa(task.Request.TopN))
-			}
-		default:
-			tq.update(task.ID, func(t *Task) {
-				t.Status = TaskFailed
-				t.Result = &RunResponse{OK: false, ExitCode: -1, Error: "未知任务类型"}
-				now := time.Now()
-				t.FinishedAt = &now
-			})
-			tq.broadcastTask(task)
-			if tq.store != nil {
-				if err := tq.store.UpdateTask(task.ID, task); err != nil {
-					log.Printf("更新任务失败: %v", err)
-				}
-			}
-			continue
+		// 先看看有没有认领超过可见性超时还没 Ack 的旧消息：这意味着上一个
+		// 拿到它的 worker 大概率已经崩溃了。
+		if qt, ok, err := b.reclaimOne(ctx); err != nil {
+			return QueuedTask{}, err
+		} else if ok {
+			return qt, nil
 		}
 
-		result := runMain(tq.cfg, func(stream, line string) {
-			tq.broadcastLog(task.ID, stream, line)
-		}, args...)
-		tq.update(task.ID, func(t *Task) {
-			if result.OK {
-				t.Status = TaskSucceeded
-			} else {
-				t.Status = TaskFailed
-				t.LastError = result.Error
+		res, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    b.group,
+			Consumer: b.consumer,
+			Streams:  []string{b.stream, ">"},
+			Count:    1,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue // 这一轮没有新消息，回去再试一次认领
 			}
-			t.Result = &result
-			now := time.Now()
-			t.FinishedAt = &now
-		})
-		tq.broadcastTask(task)
-		if !result.OK && task.Attempts <= task.MaxRetries {
-			tq.update(task.ID, func(t *Task) {
-				t.Status = TaskPending
-				t.StartedAt = nil
-				t.FinishedAt = nil
-				t.Result = nil
-			})
-			tq.broadcastTask(task)
-			delay := tq.cfg.RetryBackoff
-			if delay <= 0 {
-				delay = time.Second
+			if ctx.Err() != nil {
+				return QueuedTask{}, ctx.Err()
 			}
-			time.AfterFunc(delay, func() {
-				tq.queue <- task
-			})
+			return QueuedTask{}, err
 		}
-		if tq.store != nil {
-			if err := tq.store.UpdateTask(task.ID, task); err != nil {
-				log.Printf("更新任务失败: %v", err)
-			}
-			if result.OK {
-				if err := tq.store.SaveRunIndex(tq.cfg.RepoRoot, task); err != nil {
-					log.Printf("写入结果索引失败: %v", err)
-				}
+		for _, stream := range res {
+			for _, msg := range stream.Messages {
+				return QueuedTask{TaskID: toString(msg.Values["task_id"]), Token: msg.ID}, nil
 			}
 		}
 	}
 }
 
-func (tq *TaskQueue) update(id string, fn func(*Task)) {
-	tq.mu.Lock()
-	defer tq.mu.Unlock()
-	if task, ok := tq.tasks[id]; ok {
-		fn(task)
+func (b *RedisBackend) reclaimOne(ctx context.Context) (QueuedTask, bool, error) {
+	msgs, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   b.stream,
+		Group:    b.group,
+		Consumer: b.consumer,
+		MinIdle:  b.visibility,
+		Start:    "0",
+		Count:    1,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return QueuedTask{}, false, nil
+		}
+		return QueuedTask{}, false, err
 	}
+	if len(msgs) == 0 {
+		return QueuedTask{}, false, nil
+	}
+	msg := msgs[0]
+	return QueuedTask{TaskID: toString(msg.Values["task_id"]), Token: msg.ID}, true, nil
 }
 
-func (tq *TaskQueue) broadcastTask(task *Task) {
-	if tq.hub == nil {
-		return
-	}
-	payload := map[string]any{
-		"type": "task_update",
-		"task": task,
-	}
-	tq.hub.Broadcast(payload)
+func (b *RedisBackend) Ack(ctx context.Context, qt QueuedTask) error {
+	return b.client.XAck(ctx, b.stream, b.group, qt.Token).Err()
 }
 
-func (tq *TaskQueue) broadcastLog(taskID string, stream string, line string) {
-	if tq.hub == nil {
-		return
+// Nack 什么都不做：不 Ack 的消息留在 PEL 里，可见性超时一过就会被
+// reclaimOne 认领走，不需要额外记账。
+func (b *RedisBackend) Nack(_ context.Context, _ QueuedTask) error {
+	return nil
+}
+
+func (b *RedisBackend) LoadPending(ctx context.Context) ([]string, error) {
+	entries, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: b.stream,
+		Group:  b.group,
+		Start:  "-",
+		End:    "+",
+		Count:  1000,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	payload := map[string]any{
-		"type":    "task_log",
-		"task_id": taskID,
-		"stream":  stream,
-		"line":    line,
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		msgs, err := b.client.XRange(ctx, b.stream, entry.ID, entry.ID).Result()
+		if err != nil || len(msgs) == 0 {
+			continue
+		}
+		ids = append(ids, toString(msgs[0].Values["task_id"]))
 	}
-	tq.hub.Broadcast(payload)
+	return ids, nil
 }
 
-type Hub struct {
-	mu       sync.Mutex
-	clients  map[*websocket.Conn]struct{}
-	upgrader websocket.Upgrader
+// TaskQueue 负责任务排队与状态管理，具体排队/分发交给 backend。
+// schedItem 是 Scheduler 里排队的一条任务：按 (priority, createdAt) 排序，
+// 并记录它属于哪个 symbol（用于 symbol 间的公平调度和并发限制）。
+type schedItem struct {
+	qt        QueuedTask
+	taskID    string
+	symbol    string
+	priority  int
+	createdAt time.Time
+	index     int // 在所属 symbol 堆里的位置，heap 包维护，Reprioritize 时要用
 }
 
-func newHub() *Hub {
-	return &Hub{
-		clients: make(map[*websocket.Conn]struct{}),
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin:     func(r *http.Request) bool { return true },
-		},
+// itemHeap 是单个 symbol 下面的优先级堆：priority 数字越大越先跑，
+// 相同 priority 按 createdAt 先到先得。
+type itemHeap []*schedItem
+
+func (h itemHeap) Len() int { return len(h) }
+func (h itemHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
 	}
+	return h[i].createdAt.Before(h[j].createdAt)
+}
+func (h itemHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *itemHeap) Push(x any) {
+	item := x.(*schedItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *itemHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
 }
 
-func (h *Hub) Add(conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.clients[conn] = struct{}{}
+// Scheduler 在单个 API Bus 进程内，对 dispatchLoop 从 backend 取到的任务做
+// 本地调度：按 symbol 维护独立的优先级堆，worker 按轮询（公平调度）依次从
+// 每个有活儿、且没超过 max_concurrent_per_symbol 的 symbol 里取下一个任务，
+// 这样一个 symbol 的大量任务不会把其他 symbol 饿死。
+type Scheduler struct {
+	mu               sync.Mutex
+	cond             *sync.Cond
+	bySymbol         map[string]*itemHeap
+	byTaskID         map[string]*schedItem
+	order            []string
+	cursor           int
+	runningPerSymbol map[string]int
+	maxPerSymbol     int
 }
 
-func (h *Hub) Remove(conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.clients, conn)
+func newScheduler(maxPerSymbol int) *Scheduler {
+	s := &Scheduler{
+		bySymbol:         make(map[string]*itemHeap),
+		byTaskID:         make(map[string]*schedItem),
+		runningPerSymbol: make(map[string]int),
+		maxPerSymbol:     maxPerSymbol,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
 }
 
-func (h *Hub) Broadcast(payload any) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	for conn := range h.clients {
-		if err := conn.WriteJSON(payload); err != nil {
-			_ = conn.Close()
-			delete(h.clients, conn)
+// Push 把一条任务放进它所属 symbol 的堆里（symbol 为空字符串的任务共享
+// 一个默认桶，不受 max_concurrent_per_symbol 限制）。
+func (s *Scheduler) Push(item *schedItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.bySymbol[item.symbol]
+	if !ok {
+		h = &itemHeap{}
+		heap.Init(h)
+		s.bySymbol[item.symbol] = h
+		s.order = append(s.order, item.symbol)
+	}
+	heap.Push(h, item)
+	s.byTaskID[item.taskID] = item
+	s.cond.Broadcast()
+}
+
+// Pop 阻塞直到有任务可以运行，按 symbol 轮询公平调度。ctx 被取消时返回 ctx.Err()。
+func (s *Scheduler) Pop(ctx context.Context) (*schedItem, error) {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if item := s.popReadyLocked(); item != nil {
+			return item, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
+		s.cond.Wait()
 	}
 }
 
-func handleWS(hub *Hub) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := hub.upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			return
+func (s *Scheduler) popReadyLocked() *schedItem {
+	n := len(s.order)
+	for i := 0; i < n; i++ {
+		idx := (s.cursor + i) % n
+		symbol := s.order[idx]
+		h := s.bySymbol[symbol]
+		if h == nil || h.Len() == 0 {
+			continue
 		}
-		hub.Add(conn)
-		defer func() {
-			hub.Remove(conn)
-			_ = conn.Close()
-		}()
+		if s.maxPerSymbol > 0 && symbol != "" && s.runningPerSymbol[symbol] >= s.maxPerSymbol {
+			continue
+		}
+		item := heap.Pop(h).(*schedItem)
+		delete(s.byTaskID, item.taskID)
+		s.runningPerSymbol[symbol]++
+		s.cursor = idx + 1
+		return item
+	}
+	return nil
+}
 
-		for {
-			if _, _, err := conn.ReadMessage(); err != nil {
-				return
-			}
+// Done 标记一个 symbol 的某次运行结束，释放它占用的并发名额；如果这个
+// symbol 的堆已经空了且没有别的任务在跑，顺带把它从 order/bySymbol 里
+// 摘掉，避免 Symbol 来自用户提交、可以随意取值的情况下 order 无限增长、
+// popReadyLocked 的扫描跟着变慢。
+func (s *Scheduler) Done(symbol string) {
+	s.mu.Lock()
+	if s.runningPerSymbol[symbol] > 0 {
+		s.runningPerSymbol[symbol]--
+	}
+	s.evictIfIdleLocked(symbol)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// evictIfIdleLocked removes symbol from order/bySymbol/runningPerSymbol
+// once its heap is empty and nothing is currently running for it. Must
+// be called with s.mu held.
+func (s *Scheduler) evictIfIdleLocked(symbol string) {
+	h, ok := s.bySymbol[symbol]
+	if !ok || h.Len() != 0 || s.runningPerSymbol[symbol] != 0 {
+		return
+	}
+	for i, sym := range s.order {
+		if sym != symbol {
+			continue
 		}
+		s.order = append(s.order[:i], s.order[i+1:]...)
+		if i < s.cursor {
+			s.cursor--
+		}
+		break
 	}
+	delete(s.bySymbol, symbol)
+	delete(s.runningPerSymbol, symbol)
 }
 
-// Storage 负责持久化任务与结果索引。
-type Storage struct {
-	db *sql.DB
+// Reprioritize 修改一个还排着队、没被 Pop 走的任务的优先级，并在它所属的堆
+// 里重新排序。任务已经被某个 worker 取走时返回 false。
+func (s *Scheduler) Reprioritize(taskID string, priority int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.byTaskID[taskID]
+	if !ok {
+		return false
+	}
+	item.priority = priority
+	heap.Fix(s.bySymbol[item.symbol], item.index)
+	return true
 }
 
-// RunIndex 结果索引记录。
-type RunIndex struct {
-	TaskID     string `json:"task_id"`
-	TaskType   string `json:"task_type"`
-	ConfigPath string `json:"config_path"`
-	ResultDir  string `json:"result_dir"`
-	RunID      string `json:"run_id"`
-	Symbol     string `json:"symbol"`
-	Interval   string `json:"interval"`
-	Start      string `json:"start"`
-	End        string `json:"end"`
-	Summary    string `json:"summary_json"`
-	CreatedAt  string `json:"created_at"`
+type TaskQueue struct {
+	cfg       ServerConfig
+	backend   Backend
+	scheduler *Scheduler
+	mu        sync.RWMutex
+	tasks     map[string]*Task
+	cancels   map[string]context.CancelFunc
+	seq       uint64
+	workers   int
+	store     *Storage
+	hub       *Hub
+	logs      *LogStore
+	pyPool    *PythonPool
+
+	idemMu    sync.Mutex
+	idemLocks map[string]*idemLock
 }
 
-func newStorage(dbPath string) (*Storage, error) {
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
-		return nil, err
+// idemLock is a refcounted per-key mutex: lockIdempotencyKey/unlock pairs
+// create it lazily and drop it once the last holder releases, so distinct
+// idempotency keys don't accumulate in idemLocks forever.
+type idemLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newTaskQueue(cfg ServerConfig, workers int, store *Storage, backend Backend, logs *LogStore, pyPool *PythonPool) *TaskQueue {
+	if workers <= 0 {
+		workers = 1
 	}
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, err
+	tq := &TaskQueue{
+		cfg:       cfg,
+		backend:   backend,
+		scheduler: newScheduler(cfg.MaxConcurrentPerSymbol),
+		tasks:     make(map[string]*Task),
+		cancels:   make(map[string]context.CancelFunc),
+		workers:   workers,
+		store:     store,
+		logs:      logs,
+		pyPool:    pyPool,
+		idemLocks: make(map[string]*idemLock),
 	}
-	if err := initSchema(db); err != nil {
-		return nil, err
+	if store != nil {
+		ctx := context.Background()
+		alreadyQueued := make(map[string]bool)
+		if ids, err := backend.LoadPending(ctx); err != nil {
+			log.Printf("读取队列未完成任务失败: %v", err)
+		} else {
+			for _, id := range ids {
+				alreadyQueued[id] = true
+			}
+		}
+
+		loaded, err := store.LoadTasks(ctx)
+		if err != nil {
+			log.Printf("加载历史任务失败: %v", err)
+		} else {
+			for _, task := range loaded {
+				tq.tasks[task.ID] = task
+				// 服务重启后，把未完成任务重新入队（如果队列本身已经有它，就不用重复入队）
+				if task.Status == TaskPending || task.Status == TaskRunning {
+					task.Status = TaskPending
+					task.StartedAt = nil
+					task.FinishedAt = nil
+					if !alreadyQueued[task.ID] {
+						if err := backend.Enqueue(ctx, task.ID); err != nil {
+							log.Printf("恢复任务入队失败: %v", err)
+						}
+					}
+				}
+			}
+		}
 	}
-	return &Storage{db: db}, nil
+	go tq.dispatchLoop()
+	for i := 0; i < workers; i++ {
+		go tq.worker(i + 1)
+	}
+	return tq
 }
 
-func initSchema(db *sql.DB) error {
-	ddl := []string{
-		`CREATE TABLE IF NOT EXISTS tasks (
-			id TEXT PRIMARY KEY,
-			type TEXT NOT NULL,
-			request_json TEXT NOT NULL,
-			status TEXT NOT NULL,
-			result_json TEXT,
-			last_error TEXT,
-			attempts INTEGER DEFAULT 0,
-			max_retries INTEGER DEFAULT 0,
-			created_at TEXT NOT NULL,
-			started_at TEXT,
-			finished_at TEXT
-		);`,
-		`CREATE TABLE IF NOT EXISTS runs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			task_id TEXT NOT NULL,
-			task_type TEXT NOT NULL,
-			config_path TEXT NOT NULL,
-			result_dir TEXT NOT NULL,
-			run_id TEXT,
-			symbol TEXT,
-			interval TEXT,
-			start TEXT,
-			end TEXT,
-			summary_json TEXT,
-			created_at TEXT NOT NULL
-		);`,
+// lockIdempotencyKey 返回一个已经锁住的 unlock 函数，Enqueue 用它把"查重 +
+// 建任务 + 写幂等映射"这一整段串成一个临界区，同一个 key 的并发请求互斥。
+// idemLocks 里的条目按引用计数懒创建、用完即删，key 本身是客户端随便传的
+// 字符串，不应该无限攒在内存里。
+func (tq *TaskQueue) lockIdempotencyKey(key string) func() {
+	tq.idemMu.Lock()
+	l, ok := tq.idemLocks[key]
+	if !ok {
+		l = &idemLock{}
+		tq.idemLocks[key] = l
+	}
+	l.refs++
+	tq.idemMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		tq.idemMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(tq.idemLocks, key)
+		}
+		tq.idemMu.Unlock()
 	}
-	for _, stmt := range ddl {
-		if _, err := db.Exec(stmt); err != nil {
-			return err
+}
+
+// Enqueue 创建任务并入队。
+// Enqueue 提交一个新任务。如果 req 带了 IdempotencyKey，或者 dedupe 为 true，
+// 会先用 config_hash（必要时配上 IdempotencyKey）去查有没有 TTL 内的同一份提交，
+// 命中就直接把已有任务还回去，第二个返回值是 true；否则照常建一个新任务。
+func (tq *TaskQueue) Enqueue(ctx context.Context, taskType string, req RunRequest, dedupe bool) (*Task, bool) {
+	ctx, span := tracer.Start(ctx, "TaskQueue.Enqueue", trace.WithAttributes(
+		attribute.String("task.type", taskType),
+		attribute.String("task.symbol", req.Symbol),
+	))
+	defer span.End()
+
+	configHash := tq.hashConfig(req.Config)
+	if configHash != "" && tq.store != nil {
+		// 查重 + 建任务 + 写幂等映射必须作为一个整体串行执行，否则两个带
+		// 同一个 Idempotency-Key 的并发提交（比如重试的 HTTP 客户端）会
+		// 同时查到"没有"，各建一个 Task，后写的 SaveIdempotent 再把先写
+		// 的映射悄悄覆盖掉——幂等这个功能刚好是为了防这个场景才加的。
+		dedupeKey := req.IdempotencyKey
+		if dedupeKey == "" && dedupe {
+			dedupeKey = "confighash:" + configHash
+		}
+		if dedupeKey != "" {
+			unlock := tq.lockIdempotencyKey(dedupeKey)
+			defer unlock()
+		}
+		if req.IdempotencyKey != "" {
+			if taskID, ok, err := tq.store.FindIdempotent(ctx, req.IdempotencyKey, configHash, tq.cfg.IdempotencyTTL); err != nil {
+				log.Printf("查询幂等键失败: %v", err)
+			} else if ok {
+				if existing, ok := tq.loadTask(ctx, taskID); ok {
+					span.SetAttributes(attribute.Bool("task.deduped", true))
+					return existing, true
+				}
+			}
+		} else if dedupe {
+			if taskID, ok, err := tq.store.FindTaskByConfigHash(ctx, configHash, tq.cfg.IdempotencyTTL); err != nil {
+				log.Printf("按 config_hash 查重失败: %v", err)
+			} else if ok {
+				if existing, ok := tq.loadTask(ctx, taskID); ok {
+					span.SetAttributes(attribute.Bool("task.deduped", true))
+					return existing, true
+				}
+			}
 		}
 	}
-	if err := ensureTasksColumns(db); err != nil {
-		return err
+
+	id := tq.nextID()
+	task := &Task{
+		ID:         id,
+		Type:       taskType,
+		Request:    req,
+		Status:     TaskPending,
+		MaxRetries: tq.cfg.MaxRetries,
+		ConfigHash: configHash,
+		CreatedAt:  time.Now(),
 	}
-	if err := ensureRunsColumns(db); err != nil {
-		return err
+	tq.mu.Lock()
+	tq.tasks[id] = task
+	tq.mu.Unlock()
+	queueDepth.WithLabelValues(taskType).Inc()
+
+	if tq.store != nil {
+		if err := tq.store.SaveTask(ctx, task); err != nil {
+			log.Printf("保存任务失败: %v", err)
+		}
+		if req.IdempotencyKey != "" && configHash != "" {
+			if err := tq.store.SaveIdempotent(ctx, req.IdempotencyKey, configHash, task.ID); err != nil {
+				log.Printf("保存幂等键失败: %v", err)
+			}
+		}
 	}
-	return nil
-}
+	tq.broadcastTask(task)
 
-func (s *Storage) SaveTask(task *Task) error {
-	reqJSON, _ := json.Marshal(task.Request)
-	var resultJSON []byte
-	if task.Result != nil {
-		resultJSON, _ = json.Marshal(task.Result)
+	if err := tq.backend.Enqueue(ctx, id); err != nil {
+		log.Printf("任务入队失败: %v", err)
 	}
-	_, err := s.db.Exec(
-		`INSERT OR REPLACE INTO tasks(id, type, request_json, status, result_json, last_error, attempts, max_retries, created_at, started_at, finished_at)
-		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
-		task.ID,
-		task.Type,
-		string(reqJSON),
-		string(task.Status),
-		string(resultJSON),
-		task.LastError,
-		task.Attempts,
-		task.MaxRetries,
-		task.CreatedAt.Format(time.RFC3339),
-		timePtrToString(task.StartedAt),
-		timePtrToString(task.FinishedAt),
-	)
-	return err
+	span.SetAttributes(attribute.String("task.id", id))
+	return task, false
 }
 
-func (s *Storage) UpdateTask(id string, task *Task) error {
-	reqJSON, _ := json.Marshal(task.Request)
-	var resultJSON []byte
-	if task.Result != nil {
-		resultJSON, _ = json.Marshal(task.Result)
+// hashConfig 对 configPath 指向的 YAML 文件做规范化哈希：解析成 map 再重新
+// 编码成 JSON（key 自动按字母序排列，注释在解析时已经丢失），这样同样内容、
+// 不同格式/注释/顺序的配置文件会算出同一个 config_hash。读取或解析失败时
+// 返回空字符串，调用方按"无法判重"处理，不阻塞任务提交。
+func (tq *TaskQueue) hashConfig(configPath string) string {
+	path := configPath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(tq.cfg.RepoRoot, path)
 	}
-	_, err := s.db.Exec(
-		`UPDATE tasks SET type=?, request_json=?, status=?, result_json=?, last_error=?, attempts=?, max_retries=?, started_at=?, finished_at=? WHERE id=?;`,
-		task.Type,
-		string(reqJSON),
-		string(task.Status),
-		string(resultJSON),
-		task.LastError,
-		task.Attempts,
-		task.MaxRetries,
-		timePtrToString(task.StartedAt),
-		timePtrToString(task.FinishedAt),
-		id,
-	)
-	return err
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return ""
+	}
+	canonical, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
 }
 
-func (s *Storage) LoadTasks() ([]*Task, error) {
-	rows, err := s.db.Query(`SELECT id, type, request_json, status, result_json, last_error, attempts, max_retries, created_at, started_at, finished_at FROM tasks;`)
-	if err != nil {
-		return nil, err
+// Get 查询任务状态。
+func (tq *TaskQueue) Get(id string) (*Task, bool) {
+	tq.mu.RLock()
+	defer tq.mu.RUnlock()
+	task, ok := tq.tasks[id]
+	return task, ok
+}
+
+// Cancel 取消一个正在运行的任务：如果它当前有关联的 context.CancelFunc
+// （即确实在执行 Python 子进程），取消它并返回 true；否则返回 false。
+func (tq *TaskQueue) Cancel(id string) bool {
+	tq.mu.RLock()
+	cancel, ok := tq.cancels[id]
+	tq.mu.RUnlock()
+	if !ok {
+		return false
 	}
-	defer rows.Close()
+	cancel()
+	return true
+}
 
-	var tasks []*Task
-	for rows.Next() {
-		var id, taskType, reqJSON, status, createdAt string
-		var resultJSON sql.NullString
-		var lastError sql.NullString
-		var startedAt sql.NullString
-		var finishedAt sql.NullString
-		var attempts, maxRetries int
-		if err := rows.Scan(&id, &taskType, &reqJSON, &status, &resultJSON, &lastError, &attempts, &maxRetries, &createdAt, &startedAt, &finishedAt); err != nil {
-			return nil, err
-		}
-		task := &Task{
-			ID:         id,
-			Type:       taskType,
-			Status:     TaskStatus(status),
-			LastError:  lastError.String,
-			Attempts:   attempts,
-			MaxRetries: maxRetries,
-		}
-		_ = json.Unmarshal([]byte(reqJSON), &task.Request)
-		if resultJSON.Valid && resultJSON.String != "" {
-			var result RunResponse
-			_ = json.Unmarshal([]byte(resultJSON.String), &result)
-			task.Result = &result
+// Reprioritize 修改一个还在排队、尚未被 worker 取走的任务的优先级。
+func (tq *TaskQueue) Reprioritize(ctx context.Context, id string, priority int) bool {
+	if !tq.scheduler.Reprioritize(id, priority) {
+		return false
+	}
+	tq.update(id, func(t *Task) { t.Request.Priority = priority })
+	if task, ok := tq.Get(id); ok && tq.store != nil {
+		if err := tq.store.UpdateTask(ctx, id, task); err != nil {
+			log.Printf("更新任务失败: %v", err)
 		}
-		task.CreatedAt = parseTime(createdAt)
-		task.StartedAt = parseTimePtr(startedAt.String)
-		task.FinishedAt = parseTimePtr(finishedAt.String)
-		tasks = append(tasks, task)
 	}
-	return tasks, nil
+	return true
 }
 
-type configSnapshot struct {
-	Backtest struct {
-		Symbol   string `yaml:"symbol"`
-		Interval string `yaml:"interval"`
+// loadTask 优先从本地内存拿任务详情；如果这个任务是另一个 API Bus 实例
+// 通过共享的 Redis 队列投递过来的，本地 map 里不会有它，这时候退回去查
+// SQLite（队列权威索引）。
+func (tq *TaskQueue) loadTask(ctx context.Context, id string) (*Task, bool) {
+	tq.mu.RLock()
+	task, ok := tq.tasks[id]
+	tq.mu.RUnlock()
+	if ok {
+		return task, true
+	}
+	if tq.store == nil {
+		return nil, false
+	}
+	loaded, err := tq.store.GetTask(ctx, id)
+	if err != nil {
+		return nil, false
+	}
+	tq.mu.Lock()
+	tq.tasks[loaded.ID] = loaded
+	tq.mu.Unlock()
+	return loaded, true
+}
+
+func (tq *TaskQueue) nextID() string {
+	seq := atomic.AddUint64(&tq.seq, 1)
+	return time.Now().Format("20060102150405") + "-" + strconv.FormatUint(seq, 10)
+}
+
+// dispatchLoop 不断从 backend 取任务（这是跨实例共享/崩溃恢复那一层），
+// 并把取到的任务连同优先级、symbol 一起喂给本地的 Scheduler，由 Scheduler
+// 决定哪个 worker 先处理哪个任务。backend 和 Scheduler 因此是两层独立的关注点：
+// 前者管任务在哪儿排队、怎么跨进程分发；后者管本地按优先级和公平调度来挑活儿。
+func (tq *TaskQueue) dispatchLoop() {
+	ctx := context.Background()
+	for {
+		qt, err := tq.backend.Reserve(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("dispatch: 取任务失败: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		task, ok := tq.loadTask(ctx, qt.TaskID)
+		if !ok {
+			log.Printf("dispatch: 找不到任务 %s，跳过", qt.TaskID)
+			if err := tq.backend.Ack(ctx, qt); err != nil {
+				log.Printf("ack 失败: %v", err)
+			}
+			continue
+		}
+		tq.scheduler.Push(&schedItem{
+			qt:        qt,
+			taskID:    task.ID,
+			symbol:    task.Request.Symbol,
+			priority:  task.Request.Priority,
+			createdAt: task.CreatedAt,
+		})
+	}
+}
+
+// worker 每处理一个任务都开一个 "TaskQueue.worker" span，把正在忙的 worker
+// 数量和队列深度指标也挂在这一个单元上：Pop 到任务算出队，runTask 执行完
+// 才释放 workerBusy。
+func (tq *TaskQueue) worker(id int) {
+	ctx := context.Background()
+	for {
+		item, err := tq.scheduler.Pop(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("worker %d: 调度失败: %v", id, err)
+			continue
+		}
+		func() {
+			workerCtx, span := tracer.Start(ctx, "TaskQueue.worker", trace.WithAttributes(
+				attribute.Int("worker.id", id),
+				attribute.String("task.id", item.taskID),
+			))
+			defer span.End()
+
+			task, ok := tq.loadTask(workerCtx, item.taskID)
+			if !ok {
+				log.Printf("worker %d: 找不到任务 %s，跳过", id, item.taskID)
+				tq.scheduler.Done(item.symbol)
+				if err := tq.backend.Ack(workerCtx, item.qt); err != nil {
+					log.Printf("ack 失败: %v", err)
+				}
+				return
+			}
+			queueDepth.WithLabelValues(task.Type).Dec()
+			workerBusy.Inc()
+			tq.runTask(workerCtx, task, item.qt)
+			workerBusy.Dec()
+			tq.scheduler.Done(item.symbol)
+		}()
+	}
+}
+
+// runTask 执行单个任务的完整生命周期：标记运行中、调用 Python、落盘结果，
+// 需要重试时重新入队，最后 Ack 这次投递。
+func (tq *TaskQueue) runTask(ctx context.Context, task *Task, qt QueuedTask) {
+	taskStart := time.Now()
+	tq.update(task.ID, func(t *Task) {
+		t.Status = TaskRunning
+		t.Attempts += 1
+		now := time.Now()
+		t.StartedAt = &now
+	})
+	tq.broadcastTask(task)
+	if tq.store != nil {
+		if err := tq.store.UpdateTask(ctx, task.ID, task); err != nil {
+			log.Printf("更新任务失败: %v", err)
+		}
+	}
+
+	var params map[string]any
+	switch task.Type {
+	case "backtest":
+		params = map[string]any{"config": task.Request.Config}
+	case "sweep":
+		params = map[string]any{"config": task.Request.Config}
+		if task.Request.TopN > 0 {
+			params["top_n"] = task.Request.TopN
+		}
+	default:
+		tq.update(task.ID, func(t *Task) {
+			t.Status = TaskFailed
+			t.Result = &RunResponse{OK: false, ExitCode: -1, Error: "未知任务类型"}
+			now := time.Now()
+			t.FinishedAt = &now
+		})
+		tq.broadcastTask(task)
+		if tq.store != nil {
+			if err := tq.store.UpdateTask(ctx, task.ID, task); err != nil {
+				log.Printf("更新任务失败: %v", err)
+			}
+		}
+		taskDuration.WithLabelValues(task.Type, "false").Observe(time.Since(taskStart).Seconds())
+		if err := tq.backend.Ack(ctx, qt); err != nil {
+			log.Printf("ack 失败: %v", err)
+		}
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	tq.mu.Lock()
+	tq.cancels[task.ID] = cancel
+	tq.mu.Unlock()
+	defer func() {
+		tq.mu.Lock()
+		delete(tq.cancels, task.ID)
+		tq.mu.Unlock()
+		cancel()
+	}()
+
+	result := runMain(runCtx, tq.cfg, tq.pyPool, task.Type, params, func(stream, line, traceID string) {
+		if tq.logs == nil {
+			return
+		}
+		rec, err := tq.logs.Append(task.ID, stream, line, traceID)
+		if err != nil {
+			log.Printf("写日志失败: %v", err)
+			return
+		}
+		tq.broadcastLog(task.ID, rec)
+	})
+	taskDuration.WithLabelValues(task.Type, strconv.FormatBool(result.OK)).Observe(time.Since(taskStart).Seconds())
+	tq.update(task.ID, func(t *Task) {
+		if result.OK {
+			t.Status = TaskSucceeded
+		} else {
+			t.Status = TaskFailed
+			t.LastError = result.Error
+		}
+		t.Result = &result
+		now := time.Now()
+		t.FinishedAt = &now
+	})
+	tq.broadcastTask(task)
+
+	retry := !result.OK && task.Attempts <= task.MaxRetries
+	if retry {
+		taskRetries.Inc()
+		tq.update(task.ID, func(t *Task) {
+			t.Status = TaskPending
+			t.StartedAt = nil
+			t.FinishedAt = nil
+			t.Result = nil
+		})
+		tq.broadcastTask(task)
+	}
+
+	if tq.store != nil {
+		if err := tq.store.UpdateTask(ctx, task.ID, task); err != nil {
+			log.Printf("更新任务失败: %v", err)
+		}
+		if result.OK {
+			if err := tq.store.SaveRunIndex(ctx, tq.cfg.RepoRoot, task); err != nil {
+				log.Printf("写入结果索引失败: %v", err)
+			}
+		}
+	}
+
+	// 不管这次是成功、最终失败还是准备重试，这一条投递都处理完了：Ack 之后
+	// Redis 消费组才会把它从 PEL（待确认列表）里摘掉。
+	if err := tq.backend.Ack(ctx, qt); err != nil {
+		log.Printf("ack 失败: %v", err)
+	}
+
+	if retry {
+		delay := tq.cfg.RetryBackoff
+		if delay <= 0 {
+			delay = time.Second
+		}
+		time.AfterFunc(delay, func() {
+			if err := tq.backend.Enqueue(context.Background(), task.ID); err != nil {
+				log.Printf("重新入队失败: %v", err)
+			}
+		})
+	}
+}
+
+func (tq *TaskQueue) update(id string, fn func(*Task)) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	if task, ok := tq.tasks[id]; ok {
+		fn(task)
+	}
+}
+
+func (tq *TaskQueue) broadcastTask(task *Task) {
+	if tq.hub == nil {
+		return
+	}
+	tq.hub.broadcast(map[string]any{
+		"type": "task_update",
+		"task": task,
+	})
+}
+
+func (tq *TaskQueue) broadcastLog(taskID string, rec logRecord) {
+	if tq.hub == nil {
+		return
+	}
+	// 只发给订阅了这个 task_id 的客户端，其余客户端继续只看 task_update。
+	tq.hub.broadcastTopic(taskID, map[string]any{
+		"type":    "task_log",
+		"task_id": taskID,
+		"stream":  rec.Stream,
+		"seq":     rec.Seq,
+		"line":    rec.Line,
+		"ts":      rec.TS,
+	})
+}
+
+const (
+	logTailSize    = 200              // 内存里保留的最近日志行数，给 Tail() 兜底用
+	logRotateBytes = 10 * 1024 * 1024 // 单个 ndjson 文件达到这个大小就轮转
+	logRotateKeep  = 3                // 轮转后最多保留几个历史文件
+)
+
+// logRecord 是写进 <task_id>.ndjson 的一行结构化日志。
+type logRecord struct {
+	TS      string `json:"ts"`
+	TaskID  string `json:"task_id"`
+	Stream  string `json:"stream"`
+	Seq     uint64 `json:"seq"`
+	Line    string `json:"line"`
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+// taskLog 是单个任务的日志文件句柄 + 内存里最近的尾部窗口。
+type taskLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+	seq  uint64
+	tail []logRecord
+}
+
+// LogStore 把每个任务的日志写成按大小轮转的 NDJSON 文件（results/logs/<task_id>.ndjson），
+// 同时在内存里保留最近 logTailSize 行，供刚订阅的 WebSocket 客户端立即看到
+// 最近发生的事，不用等下一行新日志。
+type LogStore struct {
+	mu    sync.Mutex
+	dir   string
+	tasks map[string]*taskLog
+}
+
+func newLogStore(dir string) *LogStore {
+	return &LogStore{dir: dir, tasks: make(map[string]*taskLog)}
+}
+
+func (ls *LogStore) forTask(taskID string) (*taskLog, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if tl, ok := ls.tasks[taskID]; ok {
+		return tl, nil
+	}
+	if err := os.MkdirAll(ls.dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(ls.dir, taskID+".ndjson")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	tl := &taskLog{path: path, file: f, size: size}
+	ls.tasks[taskID] = tl
+	return tl, nil
+}
+
+// Append 写一条结构化日志：落盘成一行 NDJSON，同时更新内存里的尾部窗口。
+// traceID 来自触发这行日志的 runMain span，空字符串表示调用方没有开 tracing。
+func (ls *LogStore) Append(taskID, stream, line, traceID string) (logRecord, error) {
+	tl, err := ls.forTask(taskID)
+	if err != nil {
+		return logRecord{}, err
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.seq++
+	rec := logRecord{
+		TS:      time.Now().Format(time.RFC3339Nano),
+		TaskID:  taskID,
+		Stream:  stream,
+		Seq:     tl.seq,
+		Line:    line,
+		TraceID: traceID,
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	data = append(data, '\n')
+	if _, err := tl.file.Write(data); err != nil {
+		return rec, err
+	}
+	tl.size += int64(len(data))
+	if tl.size >= logRotateBytes {
+		if err := tl.rotate(); err != nil {
+			log.Printf("日志轮转失败: %v", err)
+		}
+	}
+
+	tl.tail = append(tl.tail, rec)
+	if len(tl.tail) > logTailSize {
+		tl.tail = tl.tail[len(tl.tail)-logTailSize:]
+	}
+	return rec, nil
+}
+
+// rotate 把当前文件挪成 .1（依次把旧的 .1..N-1 往后顺延到 .2..N），再开一个
+// 新文件继续写。调用方持有 tl.mu。
+func (tl *taskLog) rotate() error {
+	if err := tl.file.Close(); err != nil {
+		return err
+	}
+	for i := logRotateKeep - 1; i >= 1; i-- {
+		src := fmt.Sprintf("%s.%d", tl.path, i)
+		dst := fmt.Sprintf("%s.%d", tl.path, i+1)
+		if _, err := os.Stat(src); err == nil {
+			_ = os.Rename(src, dst)
+		}
+	}
+	_ = os.Rename(tl.path, tl.path+".1")
+
+	f, err := os.OpenFile(tl.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	tl.file = f
+	tl.size = 0
+	return nil
+}
+
+// Tail 返回内存里最近的记录，不用读盘。
+func (ls *LogStore) Tail(taskID string) []logRecord {
+	ls.mu.Lock()
+	tl, ok := ls.tasks[taskID]
+	ls.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	out := make([]logRecord, len(tl.tail))
+	copy(out, tl.tail)
+	return out
+}
+
+// ReadFrom 按顺序读取磁盘上 seq 大于 fromSeq 的记录，供 /logs?from_seq= 用。
+// 轮转会把旧内容挪到 .1/.2/...，这里只读当前文件，足够覆盖单次任务正常量级
+// 的日志；真要对账历史轮转文件，可以直接去 results/logs 目录里翻。
+func (ls *LogStore) ReadFrom(taskID string, fromSeq uint64) ([]logRecord, error) {
+	path := filepath.Join(ls.dir, taskID+".ndjson")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []logRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Seq > fromSeq {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// client 是一个 WebSocket 订阅者：自己的发送队列 + 自己的 writer goroutine，
+// 一个慢客户端只会丢自己的消息，不会卡住广播循环或者其他客户端。
+type client struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	topicMu sync.RWMutex
+	topic   string // 订阅的 task_id；空字符串表示只接收 task_update，不接收逐行日志
+}
+
+func (c *client) setTopic(topic string) {
+	c.topicMu.Lock()
+	c.topic = topic
+	c.topicMu.Unlock()
+}
+
+func (c *client) getTopic() string {
+	c.topicMu.RLock()
+	defer c.topicMu.RUnlock()
+	return c.topic
+}
+
+const (
+	clientSendBuffer = 64
+	wsWriteWait      = 10 * time.Second
+)
+
+type Hub struct {
+	mu       sync.Mutex
+	clients  map[*client]struct{}
+	upgrader websocket.Upgrader
+}
+
+// Describe/Collect 让 Hub 自己就是一个 prometheus.Collector：抓取指标时
+// 现读 h.clients 的长度，不需要额外维护一个容易和实际连接数脱节的计数器。
+func (h *Hub) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wsHubClients
+}
+
+func (h *Hub) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	n := len(h.clients)
+	h.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(wsHubClients, prometheus.GaugeValue, float64(n))
+}
+
+func newHub() *Hub {
+	return &Hub{
+		clients: make(map[*client]struct{}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+func (h *Hub) add(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+func (h *Hub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// broadcast 发给所有客户端（任务状态更新走这条路径）。
+func (h *Hub) broadcast(payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		h.enqueue(c, data)
+	}
+}
+
+// broadcastTopic 只发给订阅了这个 topic（task_id）的客户端（逐行日志走这条路径）。
+func (h *Hub) broadcastTopic(topic string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if c.getTopic() != topic {
+			continue
+		}
+		h.enqueue(c, data)
+	}
+}
+
+// enqueue 是非阻塞投递：发送队列满了就丢给这一个慢客户端的消息，不阻塞广播
+// 循环，也不影响其他客户端。
+func (h *Hub) enqueue(c *client, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("WS 客户端发送队列已满，丢弃一条消息")
+	}
+}
+
+type wsControlMessage struct {
+	Type   string `json:"type"`
+	TaskID string `json:"task_id"`
+}
+
+func handleWS(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := hub.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, send: make(chan []byte, clientSendBuffer)}
+		hub.add(c)
+
+		writeDone := make(chan struct{})
+		go func() {
+			defer close(writeDone)
+			writeClientPump(c)
+		}()
+
+		readClientPump(hub, c)
+		<-writeDone
+	}
+}
+
+func writeClientPump(c *client) {
+	for data := range c.send {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			_ = c.conn.Close()
+			return
+		}
+	}
+	_ = c.conn.Close()
+}
+
+// readClientPump 读取客户端发来的订阅控制消息，比如连接后发
+// {"type":"subscribe","task_id":"..."} 来只接收这一个任务的日志。
+func readClientPump(hub *Hub, c *client) {
+	defer hub.remove(c)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsControlMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if msg.Type == "subscribe" {
+			c.setTopic(strings.TrimSpace(msg.TaskID))
+		}
+	}
+}
+
+// Storage 负责持久化任务与结果索引。
+type Storage struct {
+	db *sql.DB
+}
+
+// trace 给一次 Storage 方法调用打点：开一个子 span，记录耗时到
+// storageLatency，方法返回后统一 span.End()。调用方用 defer s.trace(...)()。
+func (s *Storage) trace(ctx context.Context, method string) func() {
+	start := time.Now()
+	_, span := tracer.Start(ctx, "Storage."+method)
+	return func() {
+		storageLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		span.End()
+	}
+}
+
+// RunIndex 结果索引记录。
+type RunIndex struct {
+	TaskID         string `json:"task_id"`
+	TaskType       string `json:"task_type"`
+	ConfigPath     string `json:"config_path"`
+	ResultDir      string `json:"result_dir"`
+	RunID          string `json:"run_id"`
+	Symbol         string `json:"symbol"`
+	Interval       string `json:"interval"`
+	Start          string `json:"start"`
+	End            string `json:"end"`
+	Summary        string `json:"summary_json"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func newStorage(dbPath string) (*Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		return nil, err
+	}
+	return &Storage{db: db}, nil
+}
+
+func initSchema(db *sql.DB) error {
+	ddl := []string{
+		`CREATE TABLE IF NOT EXISTS tasks (
+			id TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			request_json TEXT NOT NULL,
+			status TEXT NOT NULL,
+			result_json TEXT,
+			last_error TEXT,
+			attempts INTEGER DEFAULT 0,
+			max_retries INTEGER DEFAULT 0,
+			created_at TEXT NOT NULL,
+			started_at TEXT,
+			finished_at TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id TEXT NOT NULL,
+			task_type TEXT NOT NULL,
+			config_path TEXT NOT NULL,
+			result_dir TEXT NOT NULL,
+			run_id TEXT,
+			symbol TEXT,
+			interval TEXT,
+			start TEXT,
+			end TEXT,
+			summary_json TEXT,
+			idempotency_key TEXT,
+			created_at TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS idempotency (
+			key TEXT NOT NULL,
+			config_hash TEXT NOT NULL,
+			task_id TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (key, config_hash)
+		);`,
+	}
+	for _, stmt := range ddl {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := ensureTasksColumns(db); err != nil {
+		return err
+	}
+	if err := ensureRunsColumns(db); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) SaveTask(ctx context.Context, task *Task) error {
+	defer s.trace(ctx, "SaveTask")()
+	reqJSON, _ := json.Marshal(task.Request)
+	var resultJSON []byte
+	if task.Result != nil {
+		resultJSON, _ = json.Marshal(task.Result)
+	}
+	labelsJSON, _ := json.Marshal(task.Request.Labels)
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO tasks(id, type, request_json, status, result_json, last_error, attempts, max_retries, priority, labels_json, config_hash, created_at, started_at, finished_at)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		task.ID,
+		task.Type,
+		string(reqJSON),
+		string(task.Status),
+		string(resultJSON),
+		task.LastError,
+		task.Attempts,
+		task.MaxRetries,
+		task.Request.Priority,
+		string(labelsJSON),
+		task.ConfigHash,
+		task.CreatedAt.Format(time.RFC3339),
+		timePtrToString(task.StartedAt),
+		timePtrToString(task.FinishedAt),
+	)
+	return err
+}
+
+func (s *Storage) UpdateTask(ctx context.Context, id string, task *Task) error {
+	defer s.trace(ctx, "UpdateTask")()
+	reqJSON, _ := json.Marshal(task.Request)
+	var resultJSON []byte
+	if task.Result != nil {
+		resultJSON, _ = json.Marshal(task.Result)
+	}
+	labelsJSON, _ := json.Marshal(task.Request.Labels)
+	_, err := s.db.Exec(
+		`UPDATE tasks SET type=?, request_json=?, status=?, result_json=?, last_error=?, attempts=?, max_retries=?, priority=?, labels_json=?, started_at=?, finished_at=? WHERE id=?;`,
+		task.Type,
+		string(reqJSON),
+		string(task.Status),
+		string(resultJSON),
+		task.LastError,
+		task.Attempts,
+		task.MaxRetries,
+		task.Request.Priority,
+		string(labelsJSON),
+		timePtrToString(task.StartedAt),
+		timePtrToString(task.FinishedAt),
+		id,
+	)
+	return err
+}
+
+// GetTask 按 ID 查询单条任务，供 TaskQueue 在本地内存里找不到某个任务时
+// （比如它是被另一个实例通过共享队列投递过来的）回源查询。
+func (s *Storage) GetTask(ctx context.Context, id string) (*Task, error) {
+	defer s.trace(ctx, "GetTask")()
+	row := s.db.QueryRow(`SELECT id, type, request_json, status, result_json, last_error, attempts, max_retries, config_hash, created_at, started_at, finished_at FROM tasks WHERE id = ?;`, id)
+
+	var taskID, taskType, reqJSON, status, createdAt string
+	var resultJSON sql.NullString
+	var lastError sql.NullString
+	var configHash sql.NullString
+	var startedAt sql.NullString
+	var finishedAt sql.NullString
+	var attempts, maxRetries int
+	if err := row.Scan(&taskID, &taskType, &reqJSON, &status, &resultJSON, &lastError, &attempts, &maxRetries, &configHash, &createdAt, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+
+	task := &Task{
+		ID:         taskID,
+		Type:       taskType,
+		Status:     TaskStatus(status),
+		LastError:  lastError.String,
+		Attempts:   attempts,
+		MaxRetries: maxRetries,
+		ConfigHash: configHash.String,
+	}
+	_ = json.Unmarshal([]byte(reqJSON), &task.Request)
+	if resultJSON.Valid && resultJSON.String != "" {
+		var result RunResponse
+		_ = json.Unmarshal([]byte(resultJSON.String), &result)
+		task.Result = &result
+	}
+	task.CreatedAt = parseTime(createdAt)
+	task.StartedAt = parseTimePtr(startedAt.String)
+	task.FinishedAt = parseTimePtr(finishedAt.String)
+	return task, nil
+}
+
+func (s *Storage) LoadTasks(ctx context.Context) ([]*Task, error) {
+	defer s.trace(ctx, "LoadTasks")()
+	rows, err := s.db.Query(`SELECT id, type, request_json, status, result_json, last_error, attempts, max_retries, config_hash, created_at, started_at, finished_at FROM tasks;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		var id, taskType, reqJSON, status, createdAt string
+		var resultJSON sql.NullString
+		var lastError sql.NullString
+		var configHash sql.NullString
+		var startedAt sql.NullString
+		var finishedAt sql.NullString
+		var attempts, maxRetries int
+		if err := rows.Scan(&id, &taskType, &reqJSON, &status, &resultJSON, &lastError, &attempts, &maxRetries, &configHash, &createdAt, &startedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		task := &Task{
+			ID:         id,
+			Type:       taskType,
+			Status:     TaskStatus(status),
+			LastError:  lastError.String,
+			Attempts:   attempts,
+			MaxRetries: maxRetries,
+			ConfigHash: configHash.String,
+		}
+		_ = json.Unmarshal([]byte(reqJSON), &task.Request)
+		if resultJSON.Valid && resultJSON.String != "" {
+			var result RunResponse
+			_ = json.Unmarshal([]byte(resultJSON.String), &result)
+			task.Result = &result
+		}
+		task.CreatedAt = parseTime(createdAt)
+		task.StartedAt = parseTimePtr(startedAt.String)
+		task.FinishedAt = parseTimePtr(finishedAt.String)
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// FindIdempotent 查找 (key, configHash) 在 ttl 内是否已经提交过；命中返回
+// 对应的 task_id。ttl<=0 表示永不过期。
+func (s *Storage) FindIdempotent(ctx context.Context, key, configHash string, ttl time.Duration) (string, bool, error) {
+	defer s.trace(ctx, "FindIdempotent")()
+	query := `SELECT task_id FROM idempotency WHERE key = ? AND config_hash = ?`
+	args := []any{key, configHash}
+	if ttl > 0 {
+		query += ` AND created_at >= ?`
+		args = append(args, time.Now().Add(-ttl).Format(time.RFC3339))
+	}
+	var taskID string
+	err := s.db.QueryRow(query, args...).Scan(&taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return taskID, true, nil
+}
+
+// SaveIdempotent 记录一次 (key, configHash) -> task_id 的映射，供后续重复
+// 提交复用。
+func (s *Storage) SaveIdempotent(ctx context.Context, key, configHash, taskID string) error {
+	defer s.trace(ctx, "SaveIdempotent")()
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO idempotency(key, config_hash, task_id, created_at) VALUES(?, ?, ?, ?);`,
+		key, configHash, taskID, time.Now().Format(time.RFC3339),
+	)
+	return err
+}
+
+// FindTaskByConfigHash 在没有幂等键、但调用方传了 dedupe=true 时使用：在 ttl
+// 内找最近一条 config_hash 相同的任务。
+func (s *Storage) FindTaskByConfigHash(ctx context.Context, configHash string, ttl time.Duration) (string, bool, error) {
+	defer s.trace(ctx, "FindTaskByConfigHash")()
+	query := `SELECT id FROM tasks WHERE config_hash = ?`
+	args := []any{configHash}
+	if ttl > 0 {
+		query += ` AND created_at >= ?`
+		args = append(args, time.Now().Add(-ttl).Format(time.RFC3339))
+	}
+	query += ` ORDER BY created_at DESC LIMIT 1;`
+	var taskID string
+	err := s.db.QueryRow(query, args...).Scan(&taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return taskID, true, nil
+}
+
+type configSnapshot struct {
+	Backtest struct {
+		Symbol   string `yaml:"symbol"`
+		Interval string `yaml:"interval"`
 		Start    string `yaml:"start"`
 		End      string `yaml:"end"`
 	} `yaml:"backtest"`
 }
 
-func (s *Storage) SaveRunIndex(repoRoot string, task *Task) error {
-	cfgPath := task.Request.Config
-	if !filepath.IsAbs(cfgPath) {
-		cfgPath = filepath.Join(repoRoot, cfgPath)
+func (s *Storage) SaveRunIndex(ctx context.Context, repoRoot string, task *Task) error {
+	defer s.trace(ctx, "SaveRunIndex")()
+	cfgPath := task.Request.Config
+	if !filepath.IsAbs(cfgPath) {
+		cfgPath = filepath.Join(repoRoot, cfgPath)
+	}
+	cfgData, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return err
+	}
+	var snapshot configSnapshot
+	if err := yaml.Unmarshal(cfgData, &snapshot); err != nil {
+		return err
+	}
+	symbol := snapshot.Backtest.Symbol
+	interval := snapshot.Backtest.Interval
+	start := snapshot.Backtest.Start
+	end := snapshot.Backtest.End
+	if symbol == "" || interval == "" || start == "" || end == "" {
+		return errors.New("配置缺少 backtest 关键字段")
+	}
+	baseDir := filepath.Join(repoRoot, "results", task.Type, symbol, interval, start+"_"+end)
+	// 用 findLatestDirDeep 而不是目录自己的 ModTime()：worker 写完一个 run
+	// 之后经常是原地重写 summary.json/meta.json，这在不少文件系统上不会
+	// 更新 run 目录自身的 mtime，挑出来的"最新"目录就会是错的。
+	latestDir, err := findLatestDirDeep(baseDir, true, false)
+	if err != nil {
+		return err
+	}
+	if latestDir == "" {
+		return errors.New("未找到结果目录")
+	}
+
+	runID := filepath.Base(latestDir)
+	metaPath := filepath.Join(latestDir, "meta.json")
+	if metaData, err := os.ReadFile(metaPath); err == nil {
+		var meta map[string]any
+		if json.Unmarshal(metaData, &meta) == nil {
+			if v, ok := meta["run_id"]; ok {
+				runID = toString(v)
+			}
+		}
+	}
+
+	summaryPath := filepath.Join(latestDir, "summary.json")
+	summaryJSON := ""
+	if summaryData, err := os.ReadFile(summaryPath); err == nil {
+		summaryJSON = string(summaryData)
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO runs(task_id, task_type, config_path, result_dir, run_id, symbol, interval, start, end, summary_json, idempotency_key, created_at)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		task.ID,
+		task.Type,
+		cfgPath,
+		latestDir,
+		runID,
+		symbol,
+		interval,
+		start,
+		end,
+		summaryJSON,
+		task.Request.IdempotencyKey,
+		time.Now().Format(time.RFC3339),
+	)
+	return err
+}
+
+func (s *Storage) ListRuns(ctx context.Context, filter RunFilter) ([]RunIndex, error) {
+	defer s.trace(ctx, "ListRuns")()
+	where, args := buildRunQuery(filter)
+	query := `SELECT task_id, task_type, config_path, result_dir, run_id, symbol, interval, start, end, summary_json, idempotency_key, created_at
+		FROM runs` + where + ` ORDER BY id DESC LIMIT ?;`
+	args = append(args, filter.Limit)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunIndex
+	for rows.Next() {
+		var item RunIndex
+		var idempotencyKey sql.NullString
+		if err := rows.Scan(
+			&item.TaskID,
+			&item.TaskType,
+			&item.ConfigPath,
+			&item.ResultDir,
+			&item.RunID,
+			&item.Symbol,
+			&item.Interval,
+			&item.Start,
+			&item.End,
+			&item.Summary,
+			&idempotencyKey,
+			&item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		item.IdempotencyKey = idempotencyKey.String
+		runs = append(runs, item)
+	}
+	return runs, nil
+}
+
+type RunFilter struct {
+	Limit          int
+	TaskID         string
+	Symbol         string
+	From           string
+	To             string
+	IdempotencyKey string
+}
+
+func buildRunQuery(filter RunFilter) (string, []any) {
+	var clauses []string
+	var args []any
+	if filter.TaskID != "" {
+		clauses = append(clauses, "task_id = ?")
+		args = append(args, filter.TaskID)
+	}
+	if filter.Symbol != "" {
+		clauses = append(clauses, "symbol = ?")
+		args = append(args, filter.Symbol)
+	}
+	if filter.From != "" {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		clauses = append(clauses, "created_at <= ?")
+		args = append(args, filter.To)
+	}
+	if filter.IdempotencyKey != "" {
+		clauses = append(clauses, "idempotency_key = ?")
+		args = append(args, filter.IdempotencyKey)
+	}
+	if len(clauses) == 0 {
+		return " ", args
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func ensureRunsColumns(db *sql.DB) error {
+	cols, err := listColumns(db, "runs")
+	if err != nil {
+		return err
+	}
+	need := []string{"symbol", "interval", "start", "end", "idempotency_key"}
+	for _, col := range need {
+		if !cols[col] {
+			if _, err := db.Exec(`ALTER TABLE runs ADD COLUMN ` + col + ` TEXT;`); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func ensureTasksColumns(db *sql.DB) error {
+	cols, err := listColumns(db, "tasks")
+	if err != nil {
+		return err
+	}
+	type add struct {
+		name string
+		stmt string
+	}
+	need := []add{
+		{"last_error", "ALTER TABLE tasks ADD COLUMN last_error TEXT;"},
+		{"attempts", "ALTER TABLE tasks ADD COLUMN attempts INTEGER DEFAULT 0;"},
+		{"max_retries", "ALTER TABLE tasks ADD COLUMN max_retries INTEGER DEFAULT 0;"},
+		{"priority", "ALTER TABLE tasks ADD COLUMN priority INTEGER DEFAULT 0;"},
+		{"labels_json", "ALTER TABLE tasks ADD COLUMN labels_json TEXT;"},
+		{"config_hash", "ALTER TABLE tasks ADD COLUMN config_hash TEXT;"},
+	}
+	for _, item := range need {
+		if !cols[item.name] {
+			if _, err := db.Exec(item.stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `);`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull int
+		var dflt sql.NullString
+		var pk int
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, nil
+}
+
+// findLatestDirDeep 挑 baseDir 下"最新"的子目录，排序依据不是子目录自己的
+// ModTime()——很多文件系统里这个时间只在子项增删时才更新，文件被原地重写
+// 时不会变——而是子目录内所有常规文件里最新的 ModTime()（类似 beego 的
+// getpathTime 助手）。recursive 为 true 时递归扫描子目录的子目录，否则只看
+// 子目录的直接文件；follow 控制遇到符号链接时是否跟随。读不了的条目直接
+// 跳过，不中断整体扫描；空目录按零值时间处理，排在最后面。
+func findLatestDirDeep(baseDir string, recursive, follow bool) (string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return "", err
+	}
+	var latestPath string
+	var latestTime time.Time
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(baseDir, entry.Name())
+		t := deepestModTime(dir, recursive, follow)
+		if latestPath == "" || t.After(latestTime) {
+			latestPath = dir
+			latestTime = t
+		}
+	}
+	return latestPath, nil
+}
+
+// deepestModTime 返回 dir 下所有常规文件里最新的 ModTime()。读不了的条目
+// 直接跳过；dir 为空（或里面全是读不了的条目）时返回零值时间。
+func deepestModTime(dir string, recursive, follow bool) time.Time {
+	var latest time.Time
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return latest
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !follow {
+				continue
+			}
+			resolved, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			info = resolved
+		}
+		if info.IsDir() {
+			if recursive {
+				if t := deepestModTime(path, recursive, follow); t.After(latest) {
+					latest = t
+				}
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// snapshotTimeFormat 是快照目录名里时间戳的格式，仿 syncthing versioner.TimeFormat：
+// 本地时间、固定宽度，字典序和时间序一致，也不用像 RFC3339 文件名那样纠结
+// ':' 转义和时区歧义。
+const snapshotTimeFormat = "20060102-150405"
+
+// Snapshot 是 baseDir 下一个用 snapshotTimeFormat 命名的快照：Archived 为
+// true 时它已经被 archiveOldDirs 打包进 archive/<name>.tar.gz，Path 指向
+// 那个 .tar.gz 而不是一个目录——调用方不应该当普通目录去访问它。
+type Snapshot struct {
+	Path     string
+	Time     time.Time
+	Archived bool
+}
+
+// newSnapshotTag 给 t 生成一个快照目录名，供写入新一轮运行结果时使用。
+func newSnapshotTag(t time.Time) string {
+	return t.Format(snapshotTimeFormat)
+}
+
+// listSnapshots 列出 baseDir 下所有名字能用 snapshotTimeFormat 解析出时间的
+// 子目录，以及 defaultArchiveDirName 子目录下已经被 archiveOldDirs 打包的
+// <tag>.tar.gz（标成 Archived: true），按时间从旧到新排序。解析不出来的名字
+// 直接跳过。
+func listSnapshots(baseDir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == defaultArchiveDirName {
+			continue
+		}
+		t, err := time.ParseInLocation(snapshotTimeFormat, entry.Name(), time.Local)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, Snapshot{Path: filepath.Join(baseDir, entry.Name()), Time: t})
+	}
+
+	archiveDir := filepath.Join(baseDir, defaultArchiveDirName)
+	if archiveEntries, err := os.ReadDir(archiveDir); err == nil {
+		for _, entry := range archiveEntries {
+			if entry.IsDir() {
+				continue
+			}
+			tag, ok := strings.CutSuffix(entry.Name(), ".tar.gz")
+			if !ok {
+				continue
+			}
+			t, err := time.ParseInLocation(snapshotTimeFormat, tag, time.Local)
+			if err != nil {
+				continue
+			}
+			snaps = append(snaps, Snapshot{Path: filepath.Join(archiveDir, entry.Name()), Time: t, Archived: true})
+		}
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps, nil
+}
+
+// latestSnapshot 返回 baseDir 下时间戳最新的快照。
+func latestSnapshot(baseDir string) (Snapshot, error) {
+	snaps, err := listSnapshots(baseDir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(snaps) == 0 {
+		return Snapshot{}, fmt.Errorf("%s 下没有快照目录", baseDir)
+	}
+	return snaps[len(snaps)-1], nil
+}
+
+// ThinBucket 描述抽稀计划里的一段：从"距今 Within 那么久"往回（上一段的
+// Within 是这一段的下界）开始，每隔 Every 最多保留一个快照。Within<=0 表示
+// 没有上界，一直覆盖到最老的快照，通常放在 schedule 的最后一段。
+type ThinBucket struct {
+	Within time.Duration
+	Every  time.Duration
+}
+
+// DefaultThinSchedule：过去一天每小时留一个，过去一周（一天之后）每天留一
+// 个，再往前每周留一个。
+var DefaultThinSchedule = []ThinBucket{
+	{Within: 24 * time.Hour, Every: time.Hour},
+	{Within: 7 * 24 * time.Hour, Every: 24 * time.Hour},
+	{Within: 0, Every: 7 * 24 * time.Hour},
+}
+
+// RetentionPolicy 描述 pruneSnapshots 保留哪些快照，三条规则是"或"关系——
+// 命中任意一条就留下：
+//   - KeepLast: 最近 N 个快照总是留着（<=0 表示不启用）
+//   - KeepNewerThan: 比这个时长还新的快照留着（<=0 表示不启用）
+//   - Thin: 分段抽稀计划，见 ThinBucket（nil 表示不启用）
+type RetentionPolicy struct {
+	KeepLast      int
+	KeepNewerThan time.Duration
+	Thin          []ThinBucket
+}
+
+// pruneSnapshots 按 policy 删掉 baseDir 下不需要保留的快照目录。单个目录删
+// 除失败不会中断其余目录的清理，最后把遇到的第一个错误返回。
+func pruneSnapshots(baseDir string, policy RetentionPolicy) error {
+	snaps, err := listSnapshots(baseDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keep := make([]bool, len(snaps))
+
+	if policy.KeepLast > 0 {
+		for i := len(snaps) - policy.KeepLast; i < len(snaps); i++ {
+			if i >= 0 {
+				keep[i] = true
+			}
+		}
+	}
+
+	if policy.KeepNewerThan > 0 {
+		cutoff := now.Add(-policy.KeepNewerThan)
+		for i, s := range snaps {
+			if s.Time.After(cutoff) {
+				keep[i] = true
+			}
+		}
+	}
+
+	if len(policy.Thin) > 0 {
+		thinKeep(snaps, policy.Thin, now, keep)
+	}
+
+	var firstErr error
+	for i, s := range snaps {
+		if keep[i] {
+			continue
+		}
+		if err := os.RemoveAll(s.Path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// thinKeep 把按时间升序排列的 snaps 按 schedule 抽稀，在 keep 里标记需要
+// 保留的下标。schedule 的各段按 Within 从小到大排列：每一段覆盖
+// [上一段的 Within, 本段的 Within) 这个"距今多久"的区间，区间内按 Every
+// 切成一个个槽位，每个槽位只留最新的一个快照。
+func thinKeep(snaps []Snapshot, schedule []ThinBucket, now time.Time, keep []bool) {
+	lower := time.Duration(0)
+	for _, bucket := range schedule {
+		slots := make(map[int64]int)
+		for i, s := range snaps {
+			age := now.Sub(s.Time)
+			if age < lower {
+				continue
+			}
+			if bucket.Within > 0 && age >= bucket.Within {
+				continue
+			}
+			if bucket.Every <= 0 {
+				keep[i] = true
+				continue
+			}
+			slot := s.Time.Unix() / int64(bucket.Every/time.Second)
+			if prev, ok := slots[slot]; !ok || s.Time.After(snaps[prev].Time) {
+				slots[slot] = i
+			}
+		}
+		for _, idx := range slots {
+			keep[idx] = true
+		}
+		lower = bucket.Within
+	}
+}
+
+// defaultArchiveDirName 是 archiveOldDirs 打包结果存放位置的默认子目录名。
+const defaultArchiveDirName = "archive"
+
+// ArchiveOptions 控制 archiveOldDirs 打包时的行为。
+type ArchiveOptions struct {
+	// ArchiveDirName 打包结果存放的子目录名，相对 baseDir；留空用
+	// defaultArchiveDirName。
+	ArchiveDirName string
+}
+
+// archiveOldDirs 把 baseDir 下除最近 keepRecent 个（按 ModTime 排序）之外的
+// 每个子目录打成 baseDir/<ArchiveDirName>/<name>.tar.gz，打包成功后删除原始
+// 目录。单个目录打包失败不影响其余目录，最后把遇到的第一个错误返回。
+func archiveOldDirs(baseDir string, keepRecent int, opts ArchiveOptions) error {
+	archiveDirName := opts.ArchiveDirName
+	if archiveDirName == "" {
+		archiveDirName = defaultArchiveDirName
 	}
-	cfgData, err := os.ReadFile(cfgPath)
+	archiveDir := filepath.Join(baseDir, archiveDirName)
+
+	entries, err := os.ReadDir(baseDir)
 	if err != nil {
 		return err
 	}
-	var snapshot configSnapshot
-	if err := yaml.Unmarshal(cfgData, &snapshot); err != nil {
-		return err
+	type candidate struct {
+		name string
+		mod  time.Time
 	}
-	symbol := snapshot.Backtest.Symbol
-	interval := snapshot.Backtest.Interval
-	start := snapshot.Backtest.Start
-	end := snapshot.Backtest.End
-	if symbol == "" || interval == "" || start == "" || end == "" {
-		return errors.New("配置缺少 backtest 关键字段")
+	var dirs []candidate
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == archiveDirName {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, candidate{name: entry.Name(), mod: info.ModTime()})
 	}
-	baseDir := filepath.Join(repoRoot, "results", task.Type, symbol, interval, start+"_"+end)
-	latestDir, err := findLatestDir(baseDir)
-	if err != nil {
-		return err
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].mod.After(dirs[j].mod) })
+
+	if keepRecent < 0 {
+		keepRecent = 0
 	}
-	if latestDir == "" {
-		return errors.New("未找到结果目录")
+	if keepRecent >= len(dirs) {
+		return nil
 	}
+	toArchive := dirs[keepRecent:]
 
-	runID := filepath.Base(latestDir)
-	metaPath := filepath.Join(latestDir, "meta.json")
-	if metaData, err := os.ReadFile(metaPath); err == nil {
-		var meta map[string]any
-		if json.Unmarshal(metaData, &meta) == nil {
-			if v, ok := meta["run_id"]; ok {
-				runID = toString(v)
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, c := range toArchive {
+		srcPath := filepath.Join(baseDir, c.name)
+		dstPath := filepath.Join(archiveDir, c.name+".tar.gz")
+		if err := archiveDirTo(srcPath, dstPath); err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
+		}
+		if err := os.RemoveAll(srcPath); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
+	return firstErr
+}
 
-	summaryPath := filepath.Join(latestDir, "summary.json")
-	summaryJSON := ""
-	if summaryData, err := os.ReadFile(summaryPath); err == nil {
-		summaryJSON = string(summaryData)
+// archiveDirTo 把 srcDir 整棵目录树打成一个 tar.gz 写到 dstPath。
+func archiveDirTo(srcDir, dstPath string) error {
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	_, err = s.db.Exec(
-		`INSERT INTO runs(task_id, task_type, config_path, result_dir, run_id, symbol, interval, start, end, summary_json, created_at)
-		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
-		task.ID,
-		task.Type,
-		cfgPath,
-		latestDir,
-		runID,
-		symbol,
-		interval,
-		start,
-		end,
-		summaryJSON,
-		time.Now().Format(time.RFC3339),
-	)
-	return err
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	walkErr := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		hdr, err := fileInfoToTarHeader(path, info)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if walkErr != nil {
+		_ = tw.Close()
+		_ = gz.Close()
+		return walkErr
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
 }
 
-func (s *Storage) ListRuns(filter RunFilter) ([]RunIndex, error) {
-	where, args := buildRunQuery(filter)
-	query := `SELECT task_id, task_type, config_path, result_dir, run_id, symbol, interval, start, end, summary_json, created_at
-		FROM runs` + where + ` ORDER BY id DESC LIMIT ?;`
-	args = append(args, filter.Limit)
-	rows, err := s.db.Query(query, args...)
-	if err != nil {
-		return nil, err
+// fileInfoToTarHeader 仿 Finfo2Theader 的模式构造一个 tar.Header：按文件类型
+// 选 Typeflag——符号链接、设备文件（区分字符/块设备）、命名管道/socket，其余
+// 按普通文件处理——同时保留 mode 和 mtime。
+func fileInfoToTarHeader(path string, info os.FileInfo) (*tar.Header, error) {
+	mode := info.Mode()
+	hdr := &tar.Header{
+		Mode:    int64(mode.Perm()),
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
 	}
-	defer rows.Close()
 
-	var runs []RunIndex
-	for rows.Next() {
-		var item RunIndex
-		if err := rows.Scan(
-			&item.TaskID,
-			&item.TaskType,
-			&item.ConfigPath,
-			&item.ResultDir,
-			&item.RunID,
-			&item.Symbol,
-			&item.Interval,
-			&item.Start,
-			&item.End,
-			&item.Summary,
-			&item.CreatedAt,
-		); err != nil {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
 			return nil, err
 		}
-		runs = append(runs, item)
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = link
+		hdr.Size = 0
+	case mode&(os.ModeDevice|os.ModeCharDevice) == os.ModeDevice|os.ModeCharDevice:
+		hdr.Typeflag = tar.TypeChar
+		hdr.Size = 0
+	case mode&os.ModeDevice == os.ModeDevice:
+		hdr.Typeflag = tar.TypeBlock
+		hdr.Size = 0
+	case mode&(os.ModeNamedPipe|os.ModeSocket) != 0:
+		hdr.Typeflag = tar.TypeFifo
+		hdr.Size = 0
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	default:
+		hdr.Typeflag = tar.TypeReg
 	}
-	return runs, nil
+	return hdr, nil
 }
 
-type RunFilter struct {
-	Limit  int
-	TaskID string
-	Symbol string
-	From   string
-	To     string
+// pathWithinDir reports whether target (already joined with dir) resolves
+// to dir itself or somewhere underneath it, after cleaning. Used by
+// restoreArchive to reject tar entries (including symlink targets) that
+// try to escape destDir via "../" traversal or an absolute path.
+func pathWithinDir(dir, target string) bool {
+	dir = filepath.Clean(dir)
+	target = filepath.Clean(target)
+	if target == dir {
+		return true
+	}
+	return strings.HasPrefix(target, dir+string(filepath.Separator))
 }
 
-func buildRunQuery(filter RunFilter) (string, []any) {
-	var clauses []string
-	var args []any
-	if filter.TaskID != "" {
-		clauses = append(clauses, "task_id = ?")
-		args = append(args, filter.TaskID)
+// restoreArchive 把 archiveOldDirs 打包出来的 tar.gz 解包到 destDir 下。
+func restoreArchive(path string, destDir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	if filter.Symbol != "" {
-		clauses = append(clauses, "symbol = ?")
-		args = append(args, filter.Symbol)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
 	}
-	if filter.From != "" {
-		clauses = append(clauses, "created_at >= ?")
-		args = append(args, filter.From)
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, hdr.Name)
+		if !pathWithinDir(destDir, target) {
+			return fmt.Errorf("restoreArchive: entry %q escapes destination directory", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			linkTarget := hdr.Linkname
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
+			}
+			if !pathWithinDir(destDir, linkTarget) {
+				return fmt.Errorf("restoreArchive: symlink %q points outside destination directory", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+		_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
 	}
-	if filter.To != "" {
-		clauses = append(clauses, "created_at <= ?")
-		args = append(args, filter.To)
+}
+
+// FindOptions 描述 FindDirs 的筛选条件：Regexp 只匹配条目的 base name（不含
+// 路径）；NewerThan/OlderThan 为零值表示不限制对应方向的时间窗口；MatchDir
+// 为 true 只收集子目录，为 false 只收集普通文件；Recursive 为 true 时递归
+// 扫描所有层级的子目录；Offset/Limit 在按 mtime 排序之后做分页，<=0 表示
+// 不分页。
+type FindOptions struct {
+	Regexp    *regexp.Regexp
+	NewerThan time.Time
+	OlderThan time.Time
+	MatchDir  bool
+	Recursive bool
+	Offset    int
+	Limit     int
+}
+
+// FoundEntry 是 FindDirs 返回的一条匹配结果。
+type FoundEntry struct {
+	Path string
+	Info os.FileInfo
+}
+
+// FindDirs 在 baseDir 下按 opts 筛选条目，返回按 ModTime 从旧到新排序的结果。
+// 读不了的子目录直接跳过，不中断整体扫描。
+func FindDirs(baseDir string, opts FindOptions) ([]FoundEntry, error) {
+	var found []FoundEntry
+	if err := findDirsWalk(baseDir, opts, &found); err != nil {
+		return nil, err
 	}
-	if len(clauses) == 0 {
-		return " ", args
+	sort.Slice(found, func(i, j int) bool { return found[i].Info.ModTime().Before(found[j].Info.ModTime()) })
+
+	if opts.Offset > 0 {
+		if opts.Offset >= len(found) {
+			found = nil
+		} else {
+			found = found[opts.Offset:]
+		}
 	}
-	return " WHERE " + strings.Join(clauses, " AND "), args
+	if opts.Limit > 0 && opts.Limit < len(found) {
+		found = found[:opts.Limit]
+	}
+	return found, nil
 }
 
-func ensureRunsColumns(db *sql.DB) error {
-	cols, err := listColumns(db, "runs")
+func findDirsWalk(dir string, opts FindOptions, found *[]FoundEntry) error {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
-	need := []string{"symbol", "interval", "start", "end"}
-	for _, col := range need {
-		if !cols[col] {
-			if _, err := db.Exec(`ALTER TABLE runs ADD COLUMN ` + col + ` TEXT;`); err != nil {
-				return err
-			}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() == opts.MatchDir && matchesFind(entry.Name(), info.ModTime(), opts) {
+			*found = append(*found, FoundEntry{Path: path, Info: info})
+		}
+		if entry.IsDir() && opts.Recursive {
+			_ = findDirsWalk(path, opts, found)
 		}
 	}
 	return nil
 }
 
-func ensureTasksColumns(db *sql.DB) error {
-	cols, err := listColumns(db, "tasks")
-	if err != nil {
-		return err
+func matchesFind(name string, modTime time.Time, opts FindOptions) bool {
+	if opts.Regexp != nil && !opts.Regexp.MatchString(name) {
+		return false
 	}
-	type add struct {
-		name string
-		stmt string
+	if !opts.NewerThan.IsZero() && modTime.Before(opts.NewerThan) {
+		return false
 	}
-	need := []add{
-		{"last_error", "ALTER TABLE tasks ADD COLUMN last_error TEXT;"},
-		{"attempts", "ALTER TABLE tasks ADD COLUMN attempts INTEGER DEFAULT 0;"},
-		{"max_retries", "ALTER TABLE tasks ADD COLUMN max_retries INTEGER DEFAULT 0;"},
+	if !opts.OlderThan.IsZero() && !modTime.Before(opts.OlderThan) {
+		return false
 	}
-	for _, item := range need {
-		if !cols[item.name] {
-			if _, err := db.Exec(item.stmt); err != nil {
-				return err
-			}
+	return true
+}
+
+// globToRegex 把一个只支持 `*`（任意长度）和 `?`（单字符）的 shell 风格
+// glob 编译成锚定的正则表达式，供 FindLatestMatching 使用。
+func globToRegex(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
 		}
 	}
-	return nil
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
 }
 
-func listColumns(db *sql.DB, table string) (map[string]bool, error) {
-	rows, err := db.Query(`PRAGMA table_info(` + table + `);`)
+// FindLatestMatching 是 FindDirs 的简化封装："给我 baseDir 下最近 within 时
+// 间内修改过、名字匹配 pattern（支持 * / ? 通配）的最新子目录"。within<=0
+// 表示不限制时间窗口。
+func FindLatestMatching(baseDir, pattern string, within time.Duration) (string, error) {
+	re, err := globToRegex(pattern)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rows.Close()
-	cols := make(map[string]bool)
-	for rows.Next() {
-		var cid int
-		var name, ctype string
-		var notnull int
-		var dflt sql.NullString
-		var pk int
-		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
-			return nil, err
-		}
-		cols[name] = true
+	opts := FindOptions{Regexp: re, MatchDir: true}
+	if within > 0 {
+		opts.NewerThan = time.Now().Add(-within)
 	}
-	return cols, nil
-}
-
-func findLatestDir(baseDir string) (string, error) {
-	entries, err := os.ReadDir(baseDir)
+	entries, err := FindDirs(baseDir, opts)
 	if err != nil {
 		return "", err
 	}
-	var latestPath string
-	var latestTime time.Time
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-		if latestPath == "" || info.ModTime().After(latestTime) {
-			latestPath = filepath.Join(baseDir, entry.Name())
-			latestTime = info.ModTime()
-		}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%s 下没有匹配 %q 的目录", baseDir, pattern)
 	}
-	return latestPath, nil
+	return entries[len(entries)-1].Path, nil
 }
 
 func timePtrToString(t *time.Time) string {
@@ -1009,11 +3152,63 @@ func timePtrToString(t *time.Time) string {
 	return t.Format(time.RFC3339)
 }
 
-func parseTime(val string) time.Time {
+// defaultTimeLayouts 是 TimeParser 默认按顺序尝试的时间格式：RFC3339（API 和
+// 数据库里用的主格式）、RFC1123（HTTP 响应头比如 Last-Modified 里常见）、不
+// 带时区的 "2006-01-02 15:04:05"，以及 versioner 风格的快照目录名
+// snapshotTimeFormat。数字形式的 epoch 秒/毫秒不在这个列表里，由
+// TimeParser.Parse 按位数自动识别。
+var defaultTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC1123,
+	"2006-01-02 15:04:05",
+	snapshotTimeFormat,
+}
+
+// TimeParser 按 Layouts 顺序逐个尝试解析时间字符串。零值可以直接用，这时
+// Layouts 退回到 defaultTimeLayouts。
+type TimeParser struct {
+	Layouts []string
+}
+
+var defaultTimeParser = TimeParser{Layouts: defaultTimeLayouts}
+
+// Parse 依次尝试 Layouts 里的每种格式；都失败再按字符串长度把 val 当成
+// epoch 秒（10 位）或毫秒（13 位）数字解析；全部失败返回错误。不带时区的
+// 格式（比如快照目录名）按本地时间解析，和 newSnapshotTag/listSnapshots 一致。
+func (p TimeParser) Parse(val string) (time.Time, error) {
 	if val == "" {
-		return time.Time{}
+		return time.Time{}, errors.New("空字符串")
+	}
+	layouts := p.Layouts
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		switch len(val) {
+		case 13:
+			return time.UnixMilli(n), nil
+		case 10:
+			return time.Unix(n, 0), nil
+		}
 	}
-	t, err := time.Parse(time.RFC3339, val)
+	return time.Time{}, fmt.Errorf("无法识别的时间格式: %q", val)
+}
+
+// parseTimeStrict 用 defaultTimeParser 解析 val，失败时返回错误而不是悄悄
+// 吞掉——调用方想把"空值"和"格式错误"区分开时用这个而不是 parseTime。
+func parseTimeStrict(val string) (time.Time, error) {
+	return defaultTimeParser.Parse(val)
+}
+
+// parseTime 是 parseTimeStrict 的"尽力而为"封装：空值或格式错误都悄悄返回
+// 零值时间，维持调用方原来的习惯。
+func parseTime(val string) time.Time {
+	t, err := parseTimeStrict(val)
 	if err != nil {
 		return time.Time{}
 	}
@@ -1021,10 +3216,7 @@ func parseTime(val string) time.Time {
 }
 
 func parseTimePtr(val string) *time.Time {
-	if val == "" {
-		return nil
-	}
-	t, err := time.Parse(time.RFC3339, val)
+	t, err := parseTimeStrict(val)
 	if err != nil {
 		return nil
 	}
@@ -1041,6 +3233,13 @@ func toString(v any) string {
 		return strconv.FormatInt(val, 10)
 	case int:
 		return strconv.Itoa(val)
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case *time.Time:
+		if val == nil {
+			return ""
+		}
+		return val.Format(time.RFC3339)
 	default:
 		return ""
 	}
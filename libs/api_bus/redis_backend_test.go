@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestRedisBackend 连到 REDIS_TEST_ADDR（默认 localhost:6379）建一个用
+// 随机 stream/group 名字的 RedisBackend，这样并发跑的测试不会抢同一个消费组。
+// 连不上就跳过：这是一组集成测试，机器上没有 Redis 时不应该算失败。
+func newTestRedisBackend(t *testing.T, visibility time.Duration) *RedisBackend {
+	t.Helper()
+
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	stream := "zenith:test:stream:" + t.Name()
+	group := "zenith:test:group:" + t.Name()
+	b, err := newRedisBackend(addr, stream, group, visibility)
+	if err != nil {
+		t.Skipf("没有可用的测试 Redis（%s），跳过: %v", addr, err)
+	}
+	t.Cleanup(func() {
+		b.client.Del(context.Background(), stream)
+		b.client.Close()
+	})
+	return b
+}
+
+// TestRedisBackendEnqueueReserveAck 跑一遍最常见的路径：入队、出队、确认。
+// Ack 之后同一条消息不应该再被 reclaimOne 认领回来。
+func TestRedisBackendEnqueueReserveAck(t *testing.T) {
+	b := newTestRedisBackend(t, time.Minute)
+	ctx := context.Background()
+
+	if err := b.Enqueue(ctx, "task-1"); err != nil {
+		t.Fatalf("Enqueue 失败: %v", err)
+	}
+
+	qt, err := b.Reserve(ctx)
+	if err != nil {
+		t.Fatalf("Reserve 失败: %v", err)
+	}
+	if qt.TaskID != "task-1" {
+		t.Fatalf("TaskID = %q, want %q", qt.TaskID, "task-1")
+	}
+
+	if err := b.Ack(ctx, qt); err != nil {
+		t.Fatalf("Ack 失败: %v", err)
+	}
+
+	if _, ok, err := b.reclaimOne(ctx); err != nil {
+		t.Fatalf("reclaimOne 失败: %v", err)
+	} else if ok {
+		t.Fatal("Ack 过的消息不应该再被 reclaimOne 认领")
+	}
+}
+
+// TestRedisBackendReclaimsAfterVisibilityTimeout 模拟 worker 拿到任务后崩溃
+// （没有 Ack 也没有 Nack）：可见性超时一过，reclaimOne 应该把同一条消息交还
+// 出来，而不是让它永远卡在第一个 consumer 的 PEL 里。
+func TestRedisBackendReclaimsAfterVisibilityTimeout(t *testing.T) {
+	visibility := 50 * time.Millisecond
+	b := newTestRedisBackend(t, visibility)
+	ctx := context.Background()
+
+	if err := b.Enqueue(ctx, "task-crash"); err != nil {
+		t.Fatalf("Enqueue 失败: %v", err)
+	}
+
+	first, err := b.Reserve(ctx)
+	if err != nil {
+		t.Fatalf("第一次 Reserve 失败: %v", err)
+	}
+	if first.TaskID != "task-crash" {
+		t.Fatalf("TaskID = %q, want %q", first.TaskID, "task-crash")
+	}
+
+	time.Sleep(2 * visibility)
+
+	reclaimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	second, err := b.Reserve(reclaimCtx)
+	if err != nil {
+		t.Fatalf("认领超时消息的 Reserve 失败: %v", err)
+	}
+	if second.TaskID != "task-crash" {
+		t.Fatalf("重新认领到的 TaskID = %q, want %q", second.TaskID, "task-crash")
+	}
+
+	if err := b.Ack(ctx, second); err != nil {
+		t.Fatalf("Ack 失败: %v", err)
+	}
+}
+
+// TestRedisBackendNackLeavesMessagePending 确认 Nack 目前的"什么都不做"
+// 语义：消息既不会被 Ack 掉，也不会立刻被重新认领，要等可见性超时。
+func TestRedisBackendNackLeavesMessagePending(t *testing.T) {
+	b := newTestRedisBackend(t, time.Minute)
+	ctx := context.Background()
+
+	if err := b.Enqueue(ctx, "task-nack"); err != nil {
+		t.Fatalf("Enqueue 失败: %v", err)
+	}
+	qt, err := b.Reserve(ctx)
+	if err != nil {
+		t.Fatalf("Reserve 失败: %v", err)
+	}
+
+	if err := b.Nack(ctx, qt); err != nil {
+		t.Fatalf("Nack 失败: %v", err)
+	}
+
+	if _, ok, err := b.reclaimOne(ctx); err != nil {
+		t.Fatalf("reclaimOne 失败: %v", err)
+	} else if ok {
+		t.Fatal("可见性超时未到，Nack 过的消息不应该被立即认领")
+	}
+}
+
+// TestRedisBackendLoadPending 校验 LoadPending 能看到一条已出队但还没
+// Ack 的消息的 task_id，这是进程重启后重建内存索引要用到的路径。
+func TestRedisBackendLoadPending(t *testing.T) {
+	b := newTestRedisBackend(t, time.Minute)
+	ctx := context.Background()
+
+	if err := b.Enqueue(ctx, "task-pending"); err != nil {
+		t.Fatalf("Enqueue 失败: %v", err)
+	}
+	if _, err := b.Reserve(ctx); err != nil {
+		t.Fatalf("Reserve 失败: %v", err)
+	}
+
+	pending, err := b.LoadPending(ctx)
+	if err != nil {
+		t.Fatalf("LoadPending 失败: %v", err)
+	}
+
+	found := false
+	for _, id := range pending {
+		if id == "task-pending" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("LoadPending = %v, 没有包含 %q", pending, "task-pending")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimeParserLayouts 覆盖 defaultTimeParser 支持的每种格式，以及数字形
+// 式 epoch 秒/毫秒的自动识别。
+func TestTimeParserLayouts(t *testing.T) {
+	want := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name string
+		val  string
+		want time.Time
+	}{
+		{"RFC3339", "2024-01-02T15:04:05Z", want},
+		{"RFC1123", "Tue, 02 Jan 2024 15:04:05 UTC", want},
+		{"快照目录名", "20240102-150405", time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)},
+		{"epoch 秒", "1704207845", time.Unix(1704207845, 0)},
+		{"epoch 毫秒", "1704207845000", time.UnixMilli(1704207845000)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseTimeStrict(c.val)
+			if err != nil {
+				t.Fatalf("parseTimeStrict(%q) 返回错误: %v", c.val, err)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("parseTimeStrict(%q) = %v, want %v", c.val, got, c.want)
+			}
+		})
+	}
+}
+
+// TestParseTimeStrictError 校验空值和格式错误能被区分开：parseTime 悄悄吞掉，
+// parseTimeStrict 把错误暴露给调用方。
+func TestParseTimeStrictError(t *testing.T) {
+	for _, val := range []string{"", "not-a-time", "2024/01/02"} {
+		if _, err := parseTimeStrict(val); err == nil {
+			t.Errorf("parseTimeStrict(%q) 应该返回错误", val)
+		}
+		if got := parseTime(val); !got.IsZero() {
+			t.Errorf("parseTime(%q) = %v, want 零值", val, got)
+		}
+	}
+}
+
+// TestToStringTimeRoundTrip 校验 toString 对 time.Time/*time.Time 的处理和
+// parseTimeStrict 能互相还原。
+func TestToStringTimeRoundTrip(t *testing.T) {
+	now := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)
+
+	s := toString(now)
+	got, err := parseTimeStrict(s)
+	if err != nil {
+		t.Fatalf("parseTimeStrict(%q) 返回错误: %v", s, err)
+	}
+	if !got.Equal(now) {
+		t.Errorf("round-trip time.Time: got %v, want %v", got, now)
+	}
+
+	s = toString(&now)
+	got, err = parseTimeStrict(s)
+	if err != nil {
+		t.Fatalf("parseTimeStrict(%q) 返回错误: %v", s, err)
+	}
+	if !got.Equal(now) {
+		t.Errorf("round-trip *time.Time: got %v, want %v", got, now)
+	}
+
+	var nilPtr *time.Time
+	if got := toString(nilPtr); got != "" {
+		t.Errorf("toString(nil *time.Time) = %q, want empty string", got)
+	}
+}